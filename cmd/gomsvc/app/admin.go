@@ -0,0 +1,106 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+	"github.com/inquizarus/gomsvc/pkg/logging"
+	"github.com/inquizarus/rwapper/v2"
+)
+
+// AdminConfig configures the endpoints used to inspect and control the
+// server while it is running, they are only served when enabled.
+type AdminConfig struct {
+	Path    string `json:"path"`
+	Enabled bool   `json:"enabled"`
+}
+
+func (c *AdminConfig) path() string {
+	if c == nil || c.Path == "" {
+		return defaultAdminPath
+	}
+	return strings.TrimSuffix(c.Path, "/")
+}
+
+func (c *AdminConfig) enabled() bool {
+	return c != nil && c.Enabled
+}
+
+// RegisterAdminRoutes adds the admin endpoints to router under the
+// configured path.
+func RegisterAdminRoutes(config *AdminConfig, router rwapper.RouterWrapper, log logging.Logger) {
+	methods := []string{http.MethodGet, http.MethodDelete}
+	register(router, methods, config.path()+"/breakers", MakeBreakersHandlerFunc(log))
+	register(router, methods, config.path()+"/journal", MakeJournalHandlerFunc(log))
+	log.Info("adding admin endpoints under " + config.path())
+}
+
+// MakeBreakersHandlerFunc returns a handler that lists the state of every
+// circuit breaker on GET and resets them on DELETE, either all of them or
+// only the one for the upstream given in the url query parameter.
+func MakeBreakersHandlerFunc(log logging.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			url := r.URL.Query().Get("url")
+			if !breakers.Reset(url) {
+				http.Error(w, "no circuit breaker for "+url, http.StatusNotFound)
+				return
+			}
+			if url == "" {
+				log.Info("reset all circuit breakers")
+			} else {
+				log.Info("reset circuit breaker for " + url)
+			}
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := httptools.FormatJSON(map[string]interface{}{"breakers": breakers.Statuses()})
+
+		if err != nil {
+			log.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// MakeJournalHandlerFunc returns a handler that lists the most recent
+// requests handled by routes and the callbacks they scheduled on GET, and
+// clears the journal on DELETE.
+func MakeJournalHandlerFunc(log logging.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodDelete:
+			journal.Clear()
+			log.Info("cleared request journal")
+		default:
+			w.Header().Set("Allow", http.MethodGet+", "+http.MethodDelete)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		data, err := httptools.FormatJSON(map[string]interface{}{
+			"requests":          journal.Entries(),
+			"pending_callbacks": callbacks.Pending(),
+		})
+
+		if err != nil {
+			log.Error(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
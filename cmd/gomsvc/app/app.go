@@ -1,15 +1,22 @@
 package app
 
 import (
+	"context"
 	"net/http"
 	"os"
+	"os/signal"
+	"reflect"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/inquizarus/gomsvc/pkg/logging"
 	"github.com/inquizarus/rwapper/v2"
 	"github.com/inquizarus/rwapper/v2/pkg/servemuxwrapper"
 )
 
+const defaultShutdownTimeout = 10 * time.Second
+
 func Run(router rwapper.RouterWrapper, log logging.Logger) {
 
 	if log == nil {
@@ -26,17 +33,77 @@ func Run(router rwapper.RouterWrapper, log logging.Logger) {
 		router = servemuxwrapper.New(nil)
 	}
 
-	RegisterRoutes(config, router, log)
+	if target := os.Getenv(envKeyRecordTarget); target != "" {
+		if config.Record == nil {
+			config.Record = &RecordConfig{}
+		}
+		config.Record.Target = target
+	}
+
+	if mode := os.Getenv(envKeyCassetteMode); mode != "" {
+		if config.Cassette == nil {
+			config.Cassette = &CassetteConfig{}
+		}
+		config.Cassette.Mode = mode
+	}
+
+	if path := os.Getenv(envKeyCassettePath); path != "" && config.Cassette != nil {
+		config.Cassette.Path = path
+	}
+
+	if config.Record != nil {
+		if err := RegisterRecorder(*config.Record, router, log); err != nil {
+			panic(err)
+		}
+		if config.Admin.enabled() {
+			RegisterAdminRoutes(config.Admin, router, log)
+		}
+	} else {
+		RegisterRoutes(config, router, log)
+	}
 
 	server := http.Server{
 		Addr:    config.Address(),
 		Handler: router,
 	}
 
-	log.Info("starting server on " + server.Addr)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		log.Info("starting server on " + server.Addr)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Info(err)
+		}
+		stop()
+	}()
+
+	<-ctx.Done()
+
+	shutdown(&server, config.shutdownTimeout(), log)
+}
+
+// shutdown stops accepting requests and waits for the ones being handled
+// and for pending callbacks, callbacks that are not made in time are
+// reported.
+func shutdown(server *http.Server, timeout time.Duration, log logging.Logger) {
+	log.Info("shutting down server")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Info("could not finish handling all requests, " + err.Error())
+	}
+
+	pending := WaitForCallbacks(ctx)
+
+	for _, status := range pending {
+		log.Info("callback " + status.Method + " " + status.URL + " scheduled at " + status.ScheduledAt.Format(time.RFC3339) + " was not made before shutdown")
+	}
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Info(err)
+	if len(pending) == 0 {
+		log.Info("server shut down")
 	}
 }
 
@@ -56,9 +123,84 @@ func config() (Config, error) {
 	return ConfigFromFilePath(configPath)
 }
 
+// RegisterRoutes adds all routes in config to router. Routes sharing a path
+// are served by one handler that dispatches on method, so that preflight
+// requests can be answered for every path and routers that do not separate
+// methods can serve several routes on the same path.
 func RegisterRoutes(config Config, router rwapper.RouterWrapper, log logging.Logger) {
+	paths := []string{}
+	routesByPath := map[string][]Route{}
+
 	for _, route := range config.Routes {
 		log.Info("adding route " + route.Name)
-		router.HandlerFunc(route.Method, route.Path, MakeHandlerFunc(route, config, log))
+		if _, ok := routesByPath[route.Path]; !ok {
+			paths = append(paths, route.Path)
+		}
+		routesByPath[route.Path] = append(routesByPath[route.Path], route)
+	}
+
+	for _, path := range paths {
+		routes := routesByPath[path]
+		register(router, routeMethods(routes), path, MakePathHandlerFunc(routes, config, log))
+	}
+
+	if config.Admin.enabled() {
+		RegisterAdminRoutes(config.Admin, router, log)
+	}
+}
+
+// register adds handler to router for path and each of methods, together
+// with OPTIONS so preflight requests reach the handler. Routers that do not
+// separate methods refuse a path that is already registered, so they are
+// given the path once since the handler dispatches on method by itself.
+func register(router rwapper.RouterWrapper, methods []string, path string, handler http.HandlerFunc) {
+	if ignoresMethod(router) {
+		router.HandlerFunc(methods[0], path, handler)
+		return
+	}
+
+	seen := map[string]bool{}
+	for _, method := range append(append([]string{}, methods...), http.MethodOptions) {
+		if !seen[method] {
+			seen[method] = true
+			router.HandlerFunc(method, path, handler)
+		}
+	}
+}
+
+var serveMuxRouterType = reflect.TypeOf(servemuxwrapper.New(nil))
+
+// ignoresMethod reports whether router routes requests on path alone.
+func ignoresMethod(router rwapper.RouterWrapper) bool {
+	return reflect.TypeOf(router) == serveMuxRouterType
+}
+
+// routeMethods returns the distinct methods of routes in the order they
+// are configured.
+func routeMethods(routes []Route) []string {
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, route := range routes {
+		routeMethods := []string{route.Method}
+		if route.Method == AnyMethod {
+			routeMethods = anyMethods
+		}
+		for _, method := range routeMethods {
+			if !seen[method] {
+				seen[method] = true
+				methods = append(methods, method)
+			}
+		}
+	}
+	return methods
+}
+
+// handlesMethod reports whether any of routes handles method.
+func handlesMethod(routes []Route, method string) bool {
+	for _, route := range routes {
+		if route.handles(method) {
+			return true
+		}
 	}
+	return false
 }
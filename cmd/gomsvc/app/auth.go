@@ -0,0 +1,421 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHMACAlgorithm       = "sha256"
+	defaultHMACHeader          = "X-Signature"
+	defaultHMACTimestampHeader = "X-Timestamp"
+	defaultHMACCanonical       = "{{.Method}}\n{{.Path}}\n{{.Timestamp}}\n{{.BodyHash}}"
+
+	// tokens are refreshed this long before they expire so they do not
+	// expire while a request is in flight
+	tokenExpiryMargin = 10 * time.Second
+)
+
+var hmacAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// tokens caches the tokens fetched for OAuth2 client credentials, shared by
+// all upstreams that send the same token request.
+var tokens = &tokenCache{tokens: map[string]*cachedToken{}}
+
+// Auth authenticates upstream requests. Secrets can be given as they are or
+// read from an environment variable with env: or a file with file:. When
+// more than one of Basic, Bearer and OAuth2 is set the last one wins, HMAC
+// signing is done after all of them.
+type Auth struct {
+	Basic  *BasicAuth `json:"basic"`
+	Bearer string     `json:"bearer"`
+	OAuth2 *OAuth2    `json:"oauth2"`
+	HMAC   *HMACAuth  `json:"hmac"`
+}
+
+type BasicAuth struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// OAuth2 fetches tokens with the client credentials grant from TokenURL.
+// Params are added to the token request, for example an audience.
+// Credentials are sent with basic authentication unless CredentialsInBody
+// is set.
+type OAuth2 struct {
+	TokenURL          string            `json:"token_url"`
+	ClientID          string            `json:"client_id"`
+	ClientSecret      string            `json:"client_secret"`
+	Scopes            []string          `json:"scopes"`
+	Params            map[string]string `json:"params"`
+	CredentialsInBody bool              `json:"credentials_in_body"`
+}
+
+// HMACAuth signs requests with the HMAC of a canonical string, which is a
+// template executed with the method, URL, path, query, host, headers, body,
+// hex encoded SHA-256 hash of the body and unix timestamp of the request.
+type HMACAuth struct {
+	Secret          string `json:"secret"`
+	Algorithm       string `json:"algorithm"`
+	Header          string `json:"header"`
+	Prefix          string `json:"prefix"`
+	Encoding        string `json:"encoding"`
+	TimestampHeader string `json:"timestamp_header"`
+	Canonical       string `json:"canonical"`
+}
+
+type hmacData struct {
+	Method    string
+	URL       string
+	Path      string
+	Query     string
+	Host      string
+	Headers   http.Header
+	Body      string
+	BodyHash  string
+	Timestamp string
+}
+
+type tokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]*cachedToken
+}
+
+type cachedToken struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// apply adds authentication to request, whose body is body. The secrets
+// that were used are returned so they can be redacted.
+func (a *Auth) apply(ctx context.Context, client *http.Client, request *http.Request, body []byte) ([]string, error) {
+	if a == nil {
+		return nil, nil
+	}
+
+	secrets := []string{}
+
+	if a.Basic != nil {
+		username, err := secret(a.Basic.Username)
+		if err != nil {
+			return secrets, err
+		}
+		password, err := secret(a.Basic.Password)
+		if err != nil {
+			return secrets, err
+		}
+		request.SetBasicAuth(username, password)
+		secrets = append(secrets, password, strings.TrimPrefix(request.Header.Get("Authorization"), "Basic "))
+	}
+
+	if a.Bearer != "" {
+		token, err := secret(a.Bearer)
+		if err != nil {
+			return secrets, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		secrets = append(secrets, token)
+	}
+
+	if a.OAuth2 != nil {
+		token, used, err := a.OAuth2.token(ctx, client)
+		secrets = append(secrets, used...)
+		if err != nil {
+			return secrets, err
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		secrets = append(secrets, token)
+	}
+
+	if a.HMAC != nil {
+		key, err := secret(a.HMAC.Secret)
+		if err != nil {
+			return secrets, err
+		}
+		secrets = append(secrets, key)
+		if err := a.HMAC.sign(request, body, key); err != nil {
+			return secrets, err
+		}
+	}
+
+	return secrets, nil
+}
+
+// rejected is told about upstream responses, a cached token is dropped when
+// the upstream does not accept it so a new one is fetched next time.
+func (a *Auth) rejected(response *http.Response) {
+	if a == nil || a.OAuth2 == nil || response == nil || response.StatusCode != http.StatusUnauthorized {
+		return
+	}
+	clientSecret, err := secret(a.OAuth2.ClientSecret)
+	if err != nil {
+		return
+	}
+	tokens.invalidate(a.OAuth2.key(clientSecret))
+}
+
+// token returns a cached token or fetches a new one when there is none or
+// it is about to expire. The client secret is returned as a used secret.
+func (o *OAuth2) token(ctx context.Context, client *http.Client) (string, []string, error) {
+	clientSecret, err := secret(o.ClientSecret)
+
+	if err != nil {
+		return "", nil, err
+	}
+
+	used := []string{clientSecret}
+
+	cached := tokens.get(o.key(clientSecret))
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	if cached.token != "" && (cached.expires.IsZero() || time.Now().Before(cached.expires)) {
+		return cached.token, used, nil
+	}
+
+	token, expiresIn, err := o.fetch(ctx, client, clientSecret)
+
+	if err != nil {
+		return "", used, err
+	}
+
+	cached.token = token
+	cached.expires = time.Time{}
+
+	if expiresIn > 0 {
+		cached.expires = time.Now().Add(expiresIn - tokenExpiryMargin)
+	}
+
+	return token, used, nil
+}
+
+func (o *OAuth2) fetch(ctx context.Context, client *http.Client, clientSecret string) (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+
+	for k, v := range o.Params {
+		form.Set(k, v)
+	}
+
+	if o.CredentialsInBody {
+		form.Set("client_id", o.ClientID)
+		form.Set("client_secret", clientSecret)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+
+	if !o.CredentialsInBody {
+		request.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(clientSecret))
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return "", 0, errors.New("could not fetch token from " + o.TokenURL + ", " + err.Error())
+	}
+
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return "", 0, errors.New("could not read token from " + o.TokenURL + ", " + err.Error())
+	}
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return "", 0, errors.New("could not fetch token from " + o.TokenURL + ", got status " + strconv.Itoa(response.StatusCode))
+	}
+
+	var body struct {
+		AccessToken string      `json:"access_token"`
+		ExpiresIn   json.Number `json:"expires_in"`
+	}
+
+	if err := json.Unmarshal(data, &body); err != nil || body.AccessToken == "" {
+		return "", 0, errors.New("no access token in response from " + o.TokenURL)
+	}
+
+	seconds, _ := body.ExpiresIn.Float64()
+
+	return body.AccessToken, time.Duration(seconds * float64(time.Second)), nil
+}
+
+// key returns what the tokens of o are cached under, everything that is
+// sent in the token request is part of it. The client secret is hashed so
+// it is not kept around in another place.
+func (o *OAuth2) key(clientSecret string) string {
+	params := make([]string, 0, len(o.Params))
+	for k, v := range o.Params {
+		params = append(params, url.QueryEscape(k)+"="+url.QueryEscape(v))
+	}
+	sort.Strings(params)
+
+	sum := sha256.Sum256([]byte(clientSecret))
+
+	return strings.Join([]string{
+		o.TokenURL,
+		o.ClientID,
+		hex.EncodeToString(sum[:]),
+		strings.Join(o.Scopes, " "),
+		strings.Join(params, "&"),
+	}, "\n")
+}
+
+func (c *tokenCache) get(key string) *cachedToken {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.tokens[key]
+
+	if !ok {
+		cached = &cachedToken{}
+		c.tokens[key] = cached
+	}
+
+	return cached
+}
+
+func (c *tokenCache) invalidate(key string) {
+	cached := c.get(key)
+	cached.mu.Lock()
+	defer cached.mu.Unlock()
+
+	cached.token = ""
+}
+
+// sign sets the signature of request, and the timestamp it was signed at.
+func (h *HMACAuth) sign(request *http.Request, body []byte, key string) error {
+	algorithm := h.Algorithm
+	if algorithm == "" {
+		algorithm = defaultHMACAlgorithm
+	}
+
+	newHash, ok := hmacAlgorithms[strings.ToLower(algorithm)]
+
+	if !ok {
+		return errors.New("unknown HMAC algorithm " + algorithm + ", use one of sha1, sha256 or sha512")
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	timestampHeader := h.TimestampHeader
+	if timestampHeader == "" {
+		timestampHeader = defaultHMACTimestampHeader
+	}
+	request.Header.Set(timestampHeader, timestamp)
+
+	sum := sha256.Sum256(body)
+	canonical := h.Canonical
+	if canonical == "" {
+		canonical = defaultHMACCanonical
+	}
+
+	text, err := renderTemplate(canonical, hmacData{
+		Method:    request.Method,
+		URL:       request.URL.String(),
+		Path:      request.URL.EscapedPath(),
+		Query:     request.URL.RawQuery,
+		Host:      request.URL.Host,
+		Headers:   request.Header,
+		Body:      string(body),
+		BodyHash:  hex.EncodeToString(sum[:]),
+		Timestamp: timestamp,
+	})
+
+	if err != nil {
+		return errors.New("could not render canonical string for signing, " + err.Error())
+	}
+
+	mac := hmac.New(newHash, []byte(key))
+	mac.Write([]byte(text))
+
+	signature := hex.EncodeToString(mac.Sum(nil))
+	if h.Encoding == "base64" {
+		signature = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	header := h.Header
+	if header == "" {
+		header = defaultHMACHeader
+	}
+	request.Header.Set(header, h.Prefix+signature)
+
+	return nil
+}
+
+// secret resolves value, which is either the secret itself or refers to an
+// environment variable with env: or a file with file:.
+func secret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env:"):
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+		if !ok {
+			return "", errors.New("environment variable " + name + " for upstream secret is not set")
+		}
+		return secret, nil
+	case strings.HasPrefix(value, "file:"):
+		data, err := os.ReadFile(strings.TrimPrefix(value, "file:"))
+		if err != nil {
+			return "", errors.New("could not read upstream secret, " + err.Error())
+		}
+		return string(bytes.TrimRight(data, "\r\n")), nil
+	}
+	return value, nil
+}
+
+// redact replaces every secret in text.
+func redact(text string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, defaultRedaction)
+		}
+	}
+	return text
+}
+
+// redactHeader returns a copy of header with secrets replaced.
+func redactHeader(header http.Header, secrets []string) http.Header {
+	if len(secrets) == 0 {
+		return header
+	}
+	redacted := make(http.Header, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			redacted[name] = append(redacted[name], redact(value, secrets))
+		}
+	}
+	return redacted
+}
@@ -0,0 +1,307 @@
+package app_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+// authServer answers with the authorization header it got, so tests can
+// check that secrets are redacted from the upstream section.
+func authServer(t *testing.T, authorized func(r *http.Request) bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("X-Echo-Authorization", r.Header.Get("Authorization"))
+		if !authorized(r) {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"authorization": r.Header.Get("Authorization")})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func callWithAuth(t *testing.T, log *recordingLogger, url string, auth *app.Auth) (int, string) {
+	route := app.Route{
+		Name:      "auth",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{{URL: url, Method: http.MethodGet, Auth: auth}},
+		Response: app.Response{
+			StatusCode:               http.StatusOK,
+			Headers:                  map[string]string{"content-type": "application/json"},
+			IncludeUpstreamResponses: true,
+			Passthrough:              &app.Passthrough{Status: app.PassthroughStatusWorst},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, log)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	return w.Code, w.Body.String()
+}
+
+func TestThatUpstreamsAuthenticateWithBasicAuthAndBearerTokens(t *testing.T) {
+	server := authServer(t, func(r *http.Request) bool {
+		username, password, ok := r.BasicAuth()
+		return (ok && username == "gomsvc" && password == "s3cret-password") || r.Header.Get("Authorization") == "Bearer static-token"
+	})
+
+	t.Setenv("UPSTREAM_USERNAME", "gomsvc")
+	path := filepath.Join(t.TempDir(), "password")
+	os.WriteFile(path, []byte("s3cret-password\n"), 0o600)
+
+	status, body := callWithAuth(t, &recordingLogger{}, server.URL, &app.Auth{Basic: &app.BasicAuth{Username: "env:UPSTREAM_USERNAME", Password: "file:" + path}})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"authorization": "Basic REDACTED"`)
+	assert.Contains(t, body, `"Basic REDACTED"`)
+	assert.NotContains(t, body, base64.StdEncoding.EncodeToString([]byte("gomsvc:s3cret-password")))
+
+	status, body = callWithAuth(t, &recordingLogger{}, server.URL, &app.Auth{Bearer: "static-token"})
+	assert.Equal(t, http.StatusOK, status)
+	assert.Contains(t, body, `"authorization": "Bearer REDACTED"`)
+	assert.NotContains(t, body, "static-token")
+
+	// Missing secrets fail the upstream call instead of sending no credentials
+	status, body = callWithAuth(t, &recordingLogger{}, server.URL, &app.Auth{Bearer: "env:UPSTREAM_MISSING_TOKEN"})
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Contains(t, body, "environment variable UPSTREAM_MISSING_TOKEN for upstream secret is not set")
+}
+
+func TestThatUpstreamsFetchAndCacheOAuth2Tokens(t *testing.T) {
+	var fetched int32
+	var revoked sync.Map
+	var tokenRequest http.Header
+	var tokenForm string
+
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&fetched, 1)
+		data, _ := io.ReadAll(r.Body)
+		tokenRequest, tokenForm = r.Header.Clone(), string(data)
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(`{"access_token": "token-` + strconv.Itoa(int(n)) + `", "token_type": "bearer", "expires_in": 3600}`))
+	}))
+	defer tokenServer.Close()
+
+	server := authServer(t, func(r *http.Request) bool {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		_, isRevoked := revoked.Load(token)
+		return strings.HasPrefix(token, "token-") && !isRevoked
+	})
+
+	t.Setenv("OAUTH_CLIENT_SECRET", "client-secret")
+	auth := &app.Auth{OAuth2: &app.OAuth2{
+		TokenURL:     tokenServer.URL,
+		ClientID:     "gomsvc",
+		ClientSecret: "env:OAUTH_CLIENT_SECRET",
+		Scopes:       []string{"orders.read", "orders.write"},
+		Params:       map[string]string{"audience": "orders"},
+	}}
+
+	log := &recordingLogger{}
+	for i := 0; i < 2; i++ {
+		status, body := callWithAuth(t, log, server.URL, auth)
+		assert.Equal(t, http.StatusOK, status)
+		assert.Contains(t, body, `"authorization": "Bearer REDACTED"`)
+		assert.NotContains(t, body, "token-1")
+	}
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+	assert.Equal(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("gomsvc:client-secret")), tokenRequest.Get("Authorization"))
+	assert.Equal(t, "application/x-www-form-urlencoded", tokenRequest.Get("Content-Type"))
+	assert.Contains(t, tokenForm, "grant_type=client_credentials")
+	assert.Contains(t, tokenForm, "scope=orders.read+orders.write")
+	assert.Contains(t, tokenForm, "audience=orders")
+
+	// A rejected token is dropped, so the next call gets a new one
+	revoked.Store("token-1", true)
+
+	status, _ := callWithAuth(t, log, server.URL, auth)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	status, _ = callWithAuth(t, log, server.URL, auth)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fetched))
+
+	for _, entry := range log.entries {
+		assert.NotContains(t, entry, "token-")
+		assert.NotContains(t, entry, "client-secret")
+	}
+}
+
+func TestThatOAuth2TokenErrorsFailTheUpstream(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("client_secret") != "body-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"access_token": "body-token"}`))
+	}))
+	defer tokenServer.Close()
+
+	server := authServer(t, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer body-token"
+	})
+
+	status, body := callWithAuth(t, &recordingLogger{}, server.URL, &app.Auth{OAuth2: &app.OAuth2{TokenURL: tokenServer.URL, ClientID: "a", ClientSecret: "wrong"}})
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Contains(t, body, "could not fetch token from "+tokenServer.URL+", got status 401")
+
+	status, _ = callWithAuth(t, &recordingLogger{}, server.URL, &app.Auth{OAuth2: &app.OAuth2{TokenURL: tokenServer.URL, ClientID: "b", ClientSecret: "body-secret", CredentialsInBody: true}})
+	assert.Equal(t, http.StatusOK, status)
+}
+
+func TestThatUpstreamRequestsAreSignedWithHMAC(t *testing.T) {
+	var mu sync.Mutex
+	var got *http.Request
+	var gotBody string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got, gotBody = r, string(data)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	route := app.Route{
+		Name:   "signed",
+		Path:   "/",
+		Method: http.MethodGet,
+		Upstreams: []app.Upstream{{
+			URL:     server.URL + "/orders?page=2",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"content-type": "application/json"},
+			Body:    map[string]interface{}{"id": 1},
+			Auth:    &app.Auth{HMAC: &app.HMACAuth{Secret: "signing-key"}},
+		}},
+		Response: app.Response{StatusCode: http.StatusOK},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mu.Lock()
+	sum := sha256.Sum256([]byte(gotBody))
+	mac := hmac.New(sha256.New, []byte("signing-key"))
+	mac.Write([]byte("POST\n/orders\n" + got.Header.Get("X-Timestamp") + "\n" + hex.EncodeToString(sum[:])))
+	assert.Equal(t, `{"id":1}`, gotBody)
+	assert.NotEmpty(t, got.Header.Get("X-Timestamp"))
+	assert.Equal(t, hex.EncodeToString(mac.Sum(nil)), got.Header.Get("X-Signature"))
+	mu.Unlock()
+
+	route.Upstreams[0].Auth = &app.Auth{
+		Bearer: "token",
+		HMAC: &app.HMACAuth{
+			Secret:          "signing-key",
+			Algorithm:       "sha512",
+			Header:          "X-Hub-Signature",
+			Prefix:          "sha512=",
+			Encoding:        "base64",
+			TimestampHeader: "X-Signed-At",
+			Canonical:       `{{.Method}} {{.Query}} {{.Headers.Get "Authorization"}} {{.Body}}`,
+		},
+	}
+
+	w = httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	mu.Lock()
+	mac = hmac.New(sha512.New, []byte("signing-key"))
+	mac.Write([]byte(`POST page=2 Bearer token {"id":1}`))
+	assert.NotEmpty(t, got.Header.Get("X-Signed-At"))
+	assert.Equal(t, "sha512="+base64.StdEncoding.EncodeToString(mac.Sum(nil)), got.Header.Get("X-Hub-Signature"))
+	mu.Unlock()
+}
+
+func TestThatUpstreamSecretsAreRedactedFromLogs(t *testing.T) {
+	log := &recordingLogger{}
+
+	status, body := callWithAuth(t, log, "http://127.0.0.1:1/?token=static-token", &app.Auth{Bearer: "static-token"})
+
+	assert.Equal(t, http.StatusBadGateway, status)
+	assert.Contains(t, body, "token=REDACTED")
+	assert.True(t, log.contains("token=REDACTED"))
+	for _, entry := range log.entries {
+		assert.NotContains(t, entry, "static-token")
+	}
+}
+
+func TestThatOAuth2TokensAreCachedPerTokenRequest(t *testing.T) {
+	var fetched int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&fetched, 1)
+		r.ParseForm()
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(`{"access_token": "token-` + strconv.Itoa(int(n)) + `-` + r.PostForm.Get("audience") + `", "expires_in": 3600}`))
+	}))
+	defer tokenServer.Close()
+
+	var mu sync.Mutex
+	received := []string{}
+	server := authServer(t, func(r *http.Request) bool {
+		mu.Lock()
+		received = append(received, r.Header.Get("Authorization"))
+		mu.Unlock()
+		return true
+	})
+
+	for _, call := range []struct{ audience, secret string }{
+		{audience: "orders", secret: "secret"},
+		{audience: "payments", secret: "secret"},
+		{audience: "orders", secret: "secret"},
+		{audience: "orders", secret: "rotated-secret"},
+	} {
+		status, _ := callWithAuth(t, &recordingLogger{}, server.URL, &app.Auth{OAuth2: &app.OAuth2{
+			TokenURL:     tokenServer.URL,
+			ClientID:     "audiences",
+			ClientSecret: call.secret,
+			Params:       map[string]string{"audience": call.audience},
+		}})
+		assert.Equal(t, http.StatusOK, status)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"Bearer token-1-orders", "Bearer token-2-payments", "Bearer token-1-orders", "Bearer token-3-orders"}, received)
+}
+
+func TestThatUpstreamSecretsAreRedactedFromMappings(t *testing.T) {
+	server := authServer(t, func(r *http.Request) bool { return true })
+
+	route := app.Route{
+		Name:      "auth",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{{URL: server.URL, Method: http.MethodGet, Auth: &app.Auth{Bearer: "static-token"}}},
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Mappings: []app.Mapping{
+				{Target: "body", Source: "$.upstreams[0].body.authorization"},
+				{Target: "header", Source: "$.upstreams[0].headers.X-Echo-Authorization"},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.JSONEq(t, `{"body": "Bearer REDACTED", "header": "Bearer REDACTED"}`, w.Body.String())
+}
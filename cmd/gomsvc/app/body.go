@@ -0,0 +1,193 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+)
+
+// methods that upstream requests are sent with a body for
+var bodyMethods = map[string]bool{
+	http.MethodPost:   true,
+	http.MethodPut:    true,
+	http.MethodPatch:  true,
+	http.MethodDelete: true,
+}
+
+// validate reports configuration that can not work, such as forwarding the
+// request body with a method that is sent without one.
+func (u Upstream) validate() error {
+	if u.ForwardRequestBody && !bodyMethods[u.method()] {
+		return errors.New("upstream " + u.URL + " forwards the request body but is called with " + u.method() + ", which is sent without a body")
+	}
+	return nil
+}
+
+// forwardedBody is the body of the incoming request, which is sent exactly
+// as it was received.
+type forwardedBody []byte
+
+// body returns the upstream request body, and the content type it has to be
+// sent with when the body decides it such as with multipart boundaries.
+// String bodies are sent as they are, or read from a file with file:.
+// Objects are encoded as form values or multipart form data for those
+// content types, and any JSON value is encoded as JSON when the content
+// type is JSON.
+func (u Upstream) body() (io.Reader, string, error) {
+	if !bodyMethods[u.method()] || u.Body == nil {
+		return nil, "", nil
+	}
+
+	switch body := u.Body.(type) {
+	case forwardedBody:
+		return bytes.NewReader(body), "", nil
+	case string:
+		if strings.HasPrefix(body, "file:") {
+			data, err := os.ReadFile(strings.TrimPrefix(body, "file:"))
+			if err != nil {
+				return nil, "", errors.New("could not read upstream body for " + u.URL + ", " + err.Error())
+			}
+			return bytes.NewReader(data), "", nil
+		}
+		return strings.NewReader(body), "", nil
+	}
+
+	header := http.Header{}
+	if contentType, ok := u.header("Content-Type"); ok {
+		header.Set("Content-Type", contentType)
+	}
+
+	switch {
+	case httptools.IsJSON(header):
+		data, err := json.Marshal(u.Body)
+		if err != nil {
+			return nil, "", errors.New("could not marshal upstream body for " + u.URL + ", " + err.Error())
+		}
+		return bytes.NewReader(data), "", nil
+	case httptools.IsFormURLEncoded(header):
+		fields, err := u.fields()
+		if err != nil {
+			return nil, "", err
+		}
+		values := url.Values{}
+		for _, field := range fields {
+			values[field.name] = field.values
+		}
+		return strings.NewReader(values.Encode()), "", nil
+	case httptools.IsMultipart(header):
+		return u.multipart()
+	}
+
+	return nil, "", nil
+}
+
+type bodyField struct {
+	name   string
+	values []string
+}
+
+// fields returns the fields of an object body sorted by name, arrays give
+// a field with several values.
+func (u Upstream) fields() ([]bodyField, error) {
+	object, ok := u.Body.(map[string]interface{})
+
+	if !ok {
+		return nil, errors.New("upstream body for " + u.URL + " has to be an object to be sent as form values")
+	}
+
+	fields := []bodyField{}
+
+	for name, value := range object {
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		field := bodyField{name: name}
+		for _, item := range items {
+			field.values = append(field.values, formValue(item))
+		}
+		fields = append(fields, field)
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].name < fields[j].name
+	})
+
+	return fields, nil
+}
+
+// multipart encodes an object body as multipart form data, values with a
+// file: prefix are sent as files.
+func (u Upstream) multipart() (io.Reader, string, error) {
+	fields, err := u.fields()
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	for _, field := range fields {
+		for _, value := range field.values {
+			if !strings.HasPrefix(value, "file:") {
+				if err := writer.WriteField(field.name, value); err != nil {
+					return nil, "", err
+				}
+				continue
+			}
+			path := strings.TrimPrefix(value, "file:")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, "", errors.New("could not read upstream body file for " + u.URL + ", " + err.Error())
+			}
+			part, err := writer.CreateFormFile(field.name, filepath.Base(path))
+			if err != nil {
+				return nil, "", err
+			}
+			if _, err := part.Write(data); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return &buf, writer.FormDataContentType(), nil
+}
+
+// formValue returns value as it is sent in a form, strings as they are and
+// anything else as JSON.
+func formValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+// header returns the configured value of the header name, header names are
+// compared without regard to case.
+func (u Upstream) header(name string) (string, bool) {
+	for k, v := range u.Headers {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerStateClosed   = "closed"
+	BreakerStateOpen     = "open"
+	BreakerStateHalfOpen = "half_open"
+
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerOpenDuration     = 30 * time.Second
+	defaultBreakerHalfOpenProbes   = 1
+)
+
+// breakers holds the state of the circuit breakers of all upstreams, keyed
+// by URL so routes calling the same upstream share a breaker.
+var breakers = &breakerRegistry{breakers: map[string]*breaker{}}
+
+// CircuitBreaker stops calling an upstream after a number of consecutive
+// failures, which are transport errors and 5xx responses. After being open
+// for a while a number of probe calls are let through, the breaker closes
+// when all of them succeed and opens again as soon as one of them fails.
+// Calls made while the breaker is open get the fallback as response.
+type CircuitBreaker struct {
+	FailureThreshold int               `json:"failure_threshold"`
+	OpenDuration     Duration          `json:"open_duration"`
+	HalfOpenProbes   int               `json:"half_open_probes"`
+	Fallback         interface{}       `json:"fallback"`
+	FallbackStatus   int               `json:"fallback_status"`
+	FallbackHeaders  map[string]string `json:"fallback_headers"`
+}
+
+type breaker struct {
+	mu        sync.Mutex
+	config    CircuitBreaker
+	state     string
+	failures  int
+	openedAt  time.Time
+	probing   int
+	successes int
+}
+
+type breakerRegistry struct {
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// BreakerStatus describes the state of the circuit breaker of an upstream.
+type BreakerStatus struct {
+	URL      string     `json:"url"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
+}
+
+// get returns the breaker for url, creating a closed one if needed. The
+// configuration is updated on every call so the latest one is used.
+func (r *breakerRegistry) get(url string, config CircuitBreaker) *breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, ok := r.breakers[url]
+
+	if !ok {
+		current = &breaker{state: BreakerStateClosed}
+		r.breakers[url] = current
+	}
+
+	current.mu.Lock()
+	current.config = config
+	current.mu.Unlock()
+
+	return current
+}
+
+// Statuses returns the state of every breaker sorted by URL.
+func (r *breakerRegistry) Statuses() []BreakerStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	statuses := []BreakerStatus{}
+
+	for url, current := range r.breakers {
+		statuses = append(statuses, current.status(url))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].URL < statuses[j].URL
+	})
+
+	return statuses
+}
+
+// Reset closes the breaker for url, or every breaker when url is empty. It
+// returns false when there is no breaker for url.
+func (r *breakerRegistry) Reset(url string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if url == "" {
+		for _, current := range r.breakers {
+			current.reset()
+		}
+		return true
+	}
+
+	current, ok := r.breakers[url]
+
+	if ok {
+		current.reset()
+	}
+
+	return ok
+}
+
+// allow tells if a call may be made, an open breaker turns half-open once
+// it has been open long enough.
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerStateOpen && time.Since(b.openedAt) >= b.config.openDuration() {
+		b.state = BreakerStateHalfOpen
+		b.probing = 0
+		b.successes = 0
+	}
+
+	switch b.state {
+	case BreakerStateOpen:
+		return false
+	case BreakerStateHalfOpen:
+		if b.probing+b.successes >= b.config.halfOpenProbes() {
+			return false
+		}
+		b.probing++
+	}
+
+	return true
+}
+
+// record updates the breaker with the outcome of a call it allowed.
+func (b *breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerStateHalfOpen:
+		if b.probing > 0 {
+			b.probing--
+		}
+		if failed {
+			b.open()
+			return
+		}
+		b.successes++
+		if b.successes >= b.config.halfOpenProbes() {
+			b.state = BreakerStateClosed
+			b.failures = 0
+		}
+	case BreakerStateClosed:
+		if !failed {
+			b.failures = 0
+			return
+		}
+		b.failures++
+		if b.failures >= b.config.failureThreshold() {
+			b.open()
+		}
+	}
+}
+
+func (b *breaker) open() {
+	b.state = BreakerStateOpen
+	b.openedAt = time.Now()
+	b.probing = 0
+	b.successes = 0
+}
+
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerStateClosed
+	b.failures = 0
+	b.probing = 0
+	b.successes = 0
+}
+
+func (b *breaker) status(url string) BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{URL: url, State: b.state, Failures: b.failures}
+
+	if b.state != BreakerStateClosed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return status
+}
+
+func (c CircuitBreaker) failureThreshold() int {
+	if c.FailureThreshold <= 0 {
+		return defaultBreakerFailureThreshold
+	}
+	return c.FailureThreshold
+}
+
+func (c CircuitBreaker) openDuration() time.Duration {
+	if c.OpenDuration.Duration <= 0 {
+		return defaultBreakerOpenDuration
+	}
+	return c.OpenDuration.Duration
+}
+
+func (c CircuitBreaker) halfOpenProbes() int {
+	if c.HalfOpenProbes <= 0 {
+		return defaultBreakerHalfOpenProbes
+	}
+	return c.HalfOpenProbes
+}
+
+// fallback returns the response used in place of calling the upstream at
+// rawURL while its breaker is open, an error when there is no fallback.
+func (c CircuitBreaker) fallback(method, rawURL string) (*http.Response, error) {
+	if c.Fallback == nil {
+		return nil, errors.New("circuit breaker for " + rawURL + " is open")
+	}
+
+	var data []byte
+	contentType := "application/json"
+
+	if s, ok := c.Fallback.(string); ok {
+		data = []byte(s)
+		if !json.Valid(data) {
+			contentType = "text/plain; charset=utf-8"
+		}
+	} else {
+		var err error
+		if data, err = json.Marshal(c.Fallback); err != nil {
+			return nil, err
+		}
+	}
+
+	status := c.FallbackStatus
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	for k, v := range c.FallbackHeaders {
+		headers.Set(k, v)
+	}
+
+	request := &http.Request{Method: method, URL: &url.URL{}}
+	if parsed, err := url.Parse(rawURL); err == nil {
+		request.URL = parsed
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Header:        headers,
+		Body:          io.NopCloser(strings.NewReader(string(data))),
+		ContentLength: int64(len(data)),
+		Request:       request,
+	}, nil
+}
+
+// failed tells if the result counts as a failure for circuit breakers.
+func (result upstreamResult) failed() bool {
+	return result.err != nil || result.response.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,183 @@
+package app_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/inquizarus/rwapper/v2/pkg/servemuxwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+func breakerStates(t *testing.T, handler http.Handler) map[string]string {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_gomsvc/breakers", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Breakers []app.BreakerStatus `json:"breakers"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+
+	states := map[string]string{}
+	for _, status := range body.Breakers {
+		states[status.URL] = status.State
+	}
+	return states
+}
+
+// filterStates returns the states of the breakers for URLs on server, the
+// breakers are shared by all tests.
+func filterStates(states map[string]string, server string) map[string]string {
+	filtered := map[string]string{}
+	for url, state := range states {
+		if strings.HasPrefix(url, server) {
+			filtered[url] = state
+		}
+	}
+	return filtered
+}
+
+func TestThatCircuitBreakerShortCircuitsFailingUpstreams(t *testing.T) {
+	var calls int32
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("content-type", "application/json")
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.Write([]byte(`{"live": true}`))
+	}))
+	defer server.Close()
+
+	route := app.Route{
+		Name:   "breaker",
+		Path:   "/",
+		Method: http.MethodGet,
+		Upstreams: []app.Upstream{{
+			URL:    server.URL,
+			Method: http.MethodGet,
+			CircuitBreaker: &app.CircuitBreaker{
+				FailureThreshold: 2,
+				OpenDuration:     app.Duration{Duration: 50 * time.Millisecond},
+				Fallback:         map[string]interface{}{"cached": true},
+			},
+		}},
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Mappings: []app.Mapping{
+				{Target: "live", Source: "$.upstreams[0].body.live", Default: false},
+				{Target: "cached", Source: "$.upstreams[0].body.cached", Default: false},
+			},
+		},
+	}
+	handler := app.MakeHandlerFunc(route, app.Config{}, testLogger)
+	admin := app.MakeBreakersHandlerFunc(testLogger)
+
+	call := func() string {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w.Body.String()
+	}
+
+	call()
+	call()
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, app.BreakerStateOpen, breakerStates(t, admin)[server.URL])
+
+	assert.JSONEq(t, `{"live": false, "cached": true}`, call())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// A failing probe opens the breaker again
+	time.Sleep(60 * time.Millisecond)
+	call()
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, app.BreakerStateOpen, breakerStates(t, admin)[server.URL])
+
+	// A successful probe closes it
+	healthy.Store(true)
+	time.Sleep(60 * time.Millisecond)
+	assert.JSONEq(t, `{"live": true, "cached": false}`, call())
+	assert.Equal(t, app.BreakerStateClosed, breakerStates(t, admin)[server.URL])
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+}
+
+func TestThatOpenCircuitBreakerWithoutFallbackFailsUpstream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	route := app.Route{
+		Name:      "breaker",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{{URL: server.URL, Method: http.MethodGet, CircuitBreaker: &app.CircuitBreaker{FailureThreshold: 1}}},
+		Response: app.Response{
+			StatusCode:               http.StatusOK,
+			Headers:                  map[string]string{"content-type": "application/json"},
+			IncludeUpstreamResponses: true,
+		},
+	}
+	handler := app.MakeHandlerFunc(route, app.Config{}, testLogger)
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Contains(t, w.Body.String(), `"error": "circuit breaker for `+server.URL+` is open"`)
+	assert.Contains(t, w.Body.String(), `"circuit_breaker": "open"`)
+}
+
+func TestThatAdminEndpointResetsCircuitBreakers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	config := app.Config{Routes: []app.Route{{
+		Name:      "breaker",
+		Path:      "/call",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{{URL: server.URL + "/{{.Request.Query.Get \"id\"}}", Method: http.MethodGet, CircuitBreaker: &app.CircuitBreaker{FailureThreshold: 1}}},
+		Response:  app.Response{StatusCode: http.StatusOK},
+	}}}
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(config, router, testLogger)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_gomsvc/breakers", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	config.Admin = &app.AdminConfig{Enabled: true}
+	router = servemuxwrapper.New(nil)
+	app.RegisterRoutes(config, router, testLogger)
+
+	// Breakers are kept under the configured URL, so every rendered URL
+	// shares one
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/call?id=1", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/call?id=2", nil))
+	key := server.URL + `/{{.Request.Query.Get "id"}}`
+	assert.Equal(t, map[string]string{key: app.BreakerStateOpen}, filterStates(breakerStates(t, router), server.URL))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/_gomsvc/breakers?url="+url.QueryEscape("http://unknown"), nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/_gomsvc/breakers?url="+url.QueryEscape(key), nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, app.BreakerStateClosed, breakerStates(t, router)[key])
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/_gomsvc/breakers", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
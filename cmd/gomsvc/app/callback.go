@@ -0,0 +1,278 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/inquizarus/gomsvc/pkg/logging"
+)
+
+const (
+	CallbackStatePending   = "pending"
+	CallbackStateSucceeded = "succeeded"
+	CallbackStateFailed    = "failed"
+	CallbackStateSkipped   = "skipped"
+)
+
+// callbacks tracks the asynchronous upstream calls that are in flight so
+// shutdown can wait for them.
+var callbacks = newCallbackRegistry()
+
+var (
+	errShuttingDown = errors.New("server is shutting down")
+	errAbandoned    = errors.New("server shut down before the callback was made")
+)
+
+// CallbackStatus describes an asynchronous upstream call made after the
+// response to a request was written.
+type CallbackStatus struct {
+	URL         string                   `json:"url"`
+	Method      string                   `json:"method"`
+	State       string                   `json:"state"`
+	ScheduledAt time.Time                `json:"scheduled_at"`
+	CompletedAt *time.Time               `json:"completed_at,omitempty"`
+	StatusCode  int                      `json:"status_code,omitempty"`
+	Error       string                   `json:"error,omitempty"`
+	Attempts    []map[string]interface{} `json:"attempts"`
+}
+
+type callback struct {
+	mu    sync.Mutex
+	state CallbackStatus
+}
+
+type callbackRegistry struct {
+	mu      sync.Mutex
+	pending map[*callback]bool
+	// idle is closed when the last pending callback is done, it is only
+	// made while someone waits
+	idle chan struct{}
+	// closing is set while shutdown waits, no callbacks are scheduled then
+	closing bool
+	// ctx is canceled when shutdown gives up waiting, which abandons the
+	// callbacks that are still pending
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newCallbackRegistry() *callbackRegistry {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &callbackRegistry{pending: map[*callback]bool{}, ctx: ctx, cancel: cancel}
+}
+
+// WaitForCallbacks is called when shutting down, it waits until every
+// scheduled callback has been made or ctx is done. New callbacks are
+// refused while it waits and the ones that are still pending when ctx is
+// done are abandoned and returned.
+func WaitForCallbacks(ctx context.Context) []CallbackStatus {
+	return callbacks.wait(ctx)
+}
+
+// splitUpstreams separates the upstreams called while handling a request
+// from the ones called back after responding.
+func splitUpstreams(upstreams []Upstream) ([]Upstream, []Upstream) {
+	inline, async := []Upstream{}, []Upstream{}
+	for _, upstream := range upstreams {
+		if upstream.Async {
+			async = append(async, upstream)
+			continue
+		}
+		inline = append(inline, upstream)
+	}
+	return inline, async
+}
+
+// schedule renders upstream with data and calls it in the background once
+// its delay has passed, retrying it according to its retry policy.
+func (reg *callbackRegistry) schedule(clients upstreamClients, upstream Upstream, data templateData, r *http.Request, log logging.Logger) *callback {
+	prepared, run, err := upstream.prepare(data)
+
+	cb := &callback{state: CallbackStatus{
+		URL:         prepared.url(),
+		Method:      prepared.method(),
+		State:       CallbackStatePending,
+		ScheduledAt: time.Now(),
+		Attempts:    []map[string]interface{}{},
+	}}
+
+	client, clientErr := clients(prepared)
+
+	if err == nil {
+		err = clientErr
+	}
+
+	switch {
+	case err != nil:
+		cb.state.State = CallbackStateFailed
+		cb.state.Error = err.Error()
+		log.Info("could not prepare callback to " + upstream.url() + ", " + err.Error())
+		return cb
+	case !run:
+		cb.state.State = CallbackStateSkipped
+		return cb
+	}
+
+	reg.mu.Lock()
+	if reg.closing {
+		reg.mu.Unlock()
+		cb.state.State = CallbackStateFailed
+		cb.state.Error = errShuttingDown.Error()
+		log.Info("could not schedule callback to " + cb.state.URL + ", " + errShuttingDown.Error())
+		return cb
+	}
+	reg.pending[cb] = true
+	ctx := reg.ctx
+	reg.mu.Unlock()
+
+	// The incoming request is done with by the time the callback is made,
+	// only its headers are kept around
+	req := r.Clone(ctx)
+
+	go func() {
+		defer reg.done(cb)
+
+		if prepared.Delay.Duration > 0 {
+			timer := time.NewTimer(prepared.Delay.Duration)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				cb.abandon()
+				log.Info("callback to " + cb.state.URL + " was abandoned, " + errAbandoned.Error())
+				return
+			}
+		}
+
+		result := prepared.call(ctx, client, req)
+
+		for i, attempt := range result.attempts {
+			log.Info(result.redact("callback to " + result.url() + " attempt " + strconv.Itoa(i+1) + " " + attempt.describe()))
+		}
+
+		cb.finish(result)
+
+		status := cb.status()
+		if status.State == CallbackStateFailed {
+			log.Info("callback to " + status.URL + " failed after " + strconv.Itoa(len(status.Attempts)) + " attempts")
+		} else {
+			log.Info("callback to " + status.URL + " succeeded with status " + strconv.Itoa(status.StatusCode))
+		}
+	}()
+
+	return cb
+}
+
+func (reg *callbackRegistry) done(cb *callback) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	delete(reg.pending, cb)
+
+	if len(reg.pending) == 0 && reg.idle != nil {
+		close(reg.idle)
+		reg.idle = nil
+	}
+}
+
+// Pending returns the callbacks that have not been completed yet, oldest
+// first.
+func (reg *callbackRegistry) Pending() []CallbackStatus {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	statuses := []CallbackStatus{}
+
+	for cb := range reg.pending {
+		statuses = append(statuses, cb.status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ScheduledAt.Before(statuses[j].ScheduledAt)
+	})
+
+	return statuses
+}
+
+func (reg *callbackRegistry) wait(ctx context.Context) []CallbackStatus {
+	reg.mu.Lock()
+	reg.closing = true
+	if len(reg.pending) > 0 && reg.idle == nil {
+		reg.idle = make(chan struct{})
+	}
+	idle := reg.idle
+	reg.mu.Unlock()
+
+	pending := []CallbackStatus{}
+
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			pending = reg.Pending()
+			reg.cancel()
+		}
+	}
+
+	// Shutting down is done once this returns, so the registry is ready
+	// for callbacks again in case the process keeps running
+	reg.mu.Lock()
+	reg.closing = false
+	reg.cancel()
+	reg.ctx, reg.cancel = context.WithCancel(context.Background())
+	reg.mu.Unlock()
+
+	return pending
+}
+
+// finish records the outcome of the callback, responses with an error
+// status count as failures.
+func (cb *callback) finish(result upstreamResult) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	completed := time.Now()
+	cb.state.CompletedAt = &completed
+	cb.state.URL = result.url()
+
+	for i, attempt := range result.attempts {
+		cb.state.Attempts = append(cb.state.Attempts, result.attemptInformation(attempt, i+1))
+	}
+
+	switch {
+	case result.err != nil:
+		cb.state.State = CallbackStateFailed
+		cb.state.Error = result.redact(result.err.Error())
+	case result.response.StatusCode >= http.StatusBadRequest:
+		cb.state.State = CallbackStateFailed
+		cb.state.StatusCode = result.response.StatusCode
+	default:
+		cb.state.State = CallbackStateSucceeded
+		cb.state.StatusCode = result.response.StatusCode
+	}
+}
+
+// abandon records that the callback was never made.
+func (cb *callback) abandon() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	completed := time.Now()
+	cb.state.CompletedAt = &completed
+	cb.state.State = CallbackStateFailed
+	cb.state.Error = errAbandoned.Error()
+}
+
+func (cb *callback) status() CallbackStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := cb.state
+	status.Attempts = append([]map[string]interface{}{}, cb.state.Attempts...)
+
+	return status
+}
@@ -0,0 +1,186 @@
+package app_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/inquizarus/rwapper/v2/pkg/servemuxwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+func journalEntries(t *testing.T, handler http.Handler, route string) []app.JournalEntry {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/_gomsvc/journal", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Requests []app.JournalEntry `json:"requests"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+
+	entries := []app.JournalEntry{}
+	for _, entry := range body.Requests {
+		if entry.Route == route {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestThatAsyncUpstreamsAreCalledBackAfterResponding(t *testing.T) {
+	var calls int32
+	var mu sync.Mutex
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		json.Unmarshal(data, &received)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	config := app.Config{Routes: []app.Route{{
+		Name:   "webhook",
+		Path:   "/orders",
+		Method: http.MethodPost,
+		Upstreams: []app.Upstream{{
+			URL:     "{{.Request.Body.callback_url}}",
+			Method:  http.MethodPost,
+			Headers: map[string]string{"content-type": "application/json"},
+			Body:    map[string]interface{}{"order": "{{.Request.Body.id}}", "status": "shipped"},
+			Async:   true,
+			Delay:   app.Duration{Duration: 50 * time.Millisecond},
+			Retry:   &app.Retry{MaxAttempts: 3, Backoff: app.Duration{Duration: 10 * time.Millisecond}},
+		}},
+		Response: app.Response{StatusCode: http.StatusAccepted, Template: true, Body: `{"id": "{{.Request.Body.id}}"}`},
+	}}, Admin: &app.AdminConfig{Enabled: true}}
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(config, router, testLogger)
+
+	r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"id": "A1", "callback_url": "`+server.URL+`/hooks"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, `{"id": "A1"}`, w.Body.String())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	entries := journalEntries(t, router, "webhook")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, app.CallbackStatePending, entries[0].Callbacks[0].State)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	assert.Empty(t, app.WaitForCallbacks(ctx))
+
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	mu.Lock()
+	assert.Equal(t, map[string]interface{}{"order": "A1", "status": "shipped"}, received)
+	mu.Unlock()
+
+	entries = journalEntries(t, router, "webhook")
+	callback := entries[0].Callbacks[0]
+	assert.Equal(t, app.CallbackStateSucceeded, callback.State)
+	assert.Equal(t, server.URL+"/hooks", callback.URL)
+	assert.Equal(t, http.StatusOK, callback.StatusCode)
+	assert.Len(t, callback.Attempts, 2)
+	assert.NotNil(t, callback.CompletedAt)
+}
+
+func TestThatWaitForCallbacksReportsPendingCallbacks(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	route := app.Route{
+		Name:   "slow webhook",
+		Path:   "/slow",
+		Method: http.MethodGet,
+		Upstreams: []app.Upstream{
+			{URL: server.URL + "/skipped", Method: http.MethodGet, Async: true, If: "{{.Request.Query.Get \"notify\"}}"},
+			{URL: server.URL + "/later", Method: http.MethodGet, Async: true, Delay: app.Duration{Duration: 200 * time.Millisecond}},
+		},
+		Response: app.Response{StatusCode: http.StatusAccepted},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	pending := app.WaitForCallbacks(ctx)
+
+	assert.Len(t, pending, 1)
+	assert.Equal(t, server.URL+"/later", pending[0].URL)
+	assert.Equal(t, app.CallbackStatePending, pending[0].State)
+
+	// Callbacks still waiting for their delay are abandoned once shutdown
+	// gives up on them
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterAdminRoutes(nil, router, testLogger)
+	entries := journalEntries(t, router, "slow webhook")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, app.CallbackStateSkipped, entries[0].Callbacks[0].State)
+	assert.Equal(t, app.CallbackStateFailed, entries[0].Callbacks[1].State)
+	assert.Equal(t, "server shut down before the callback was made", entries[0].Callbacks[1].Error)
+}
+
+func TestThatCallbacksAreRefusedWhileShuttingDown(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	route := func(name string, delay time.Duration) app.Route {
+		return app.Route{
+			Name:      name,
+			Path:      "/",
+			Method:    http.MethodGet,
+			Upstreams: []app.Upstream{{URL: server.URL, Method: http.MethodGet, Async: true, Delay: app.Duration{Duration: delay}}},
+			Response:  app.Response{StatusCode: http.StatusAccepted},
+		}
+	}
+
+	app.MakeHandlerFunc(route("before shutdown", 100*time.Millisecond), app.Config{}, testLogger)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	pending := make(chan []app.CallbackStatus)
+	go func() {
+		pending <- app.WaitForCallbacks(ctx)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	app.MakeHandlerFunc(route("during shutdown", 0), app.Config{}, testLogger)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Empty(t, <-pending)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterAdminRoutes(nil, router, testLogger)
+	assert.Equal(t, app.CallbackStateSucceeded, journalEntries(t, router, "before shutdown")[0].Callbacks[0].State)
+	refused := journalEntries(t, router, "during shutdown")[0].Callbacks[0]
+	assert.Equal(t, app.CallbackStateFailed, refused.State)
+	assert.Equal(t, "server is shutting down", refused.Error)
+}
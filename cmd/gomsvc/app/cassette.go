@@ -0,0 +1,346 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	CassetteModeRecord = "record"
+	CassetteModeReplay = "replay"
+
+	CassetteMatchMethod = "method"
+	CassetteMatchURL    = "url"
+	CassetteMatchBody   = "body"
+
+	defaultCassettePath = "cassette.json"
+)
+
+var defaultCassetteMatch = []string{CassetteMatchMethod, CassetteMatchURL, CassetteMatchBody}
+
+// ErrCassetteMiss is returned for upstream requests that have no recorded
+// interaction when replaying a cassette.
+var ErrCassetteMiss = errors.New("no recorded interaction in cassette")
+
+// cassettes holds every cassette in use by path, so all routes record to
+// and replay from the same one.
+var cassettes = &cassetteRegistry{cassettes: map[string]*Cassette{}}
+
+// CassetteConfig makes upstream calls go through a cassette. In record mode
+// every upstream interaction is saved to Path, in replay mode upstream
+// requests are answered from Path without touching the network. Match
+// decides which parts of requests have to be equal, from method, url and
+// body, together with the headers in MatchHeaders.
+type CassetteConfig struct {
+	Mode         string   `json:"mode"`
+	Path         string   `json:"path"`
+	Match        []string `json:"match"`
+	MatchHeaders []string `json:"match_headers"`
+}
+
+// Cassette is a http.RoundTripper that records or replays interactions.
+type Cassette struct {
+	config       CassetteConfig
+	base         http.RoundTripper
+	mu           sync.Mutex
+	interactions []cassetteInteraction
+	used         []bool
+}
+
+type cassetteInteraction struct {
+	Request  cassetteRequest  `json:"request"`
+	Response cassetteResponse `json:"response"`
+}
+
+type cassetteRequest struct {
+	Method   string      `json:"method"`
+	URL      string      `json:"url"`
+	Headers  http.Header `json:"headers,omitempty"`
+	BodyHash string      `json:"body_hash"`
+}
+
+type cassetteResponse struct {
+	StatusCode   int         `json:"status_code"`
+	Headers      http.Header `json:"headers"`
+	Body         string      `json:"body"`
+	BodyEncoding string      `json:"body_encoding,omitempty"`
+}
+
+type cassetteFile struct {
+	Interactions []cassetteInteraction `json:"interactions"`
+}
+
+type cassetteRegistry struct {
+	mu        sync.Mutex
+	cassettes map[string]*Cassette
+}
+
+// get returns the cassette for config, loading it the first time it is
+// asked for.
+func (r *cassetteRegistry) get(config CassetteConfig) (*Cassette, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := config.Mode + ":" + config.path()
+
+	if cassette, ok := r.cassettes[key]; ok {
+		return cassette, nil
+	}
+
+	cassette, err := NewCassette(config, http.DefaultTransport)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.cassettes[key] = cassette
+
+	return cassette, nil
+}
+
+// NewCassette returns a cassette that uses base for requests it records.
+// Cassettes in replay mode are loaded from disk while cassettes in record
+// mode start empty.
+func NewCassette(config CassetteConfig, base http.RoundTripper) (*Cassette, error) {
+	cassette := &Cassette{config: config, base: base}
+
+	switch config.Mode {
+	case CassetteModeRecord:
+	case CassetteModeReplay:
+		data, err := os.ReadFile(config.path())
+		if err != nil {
+			return nil, errors.New("could not load cassette, " + err.Error())
+		}
+		var file cassetteFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, errors.New("could not decode cassette " + config.path() + ", " + err.Error())
+		}
+		cassette.interactions = file.Interactions
+		cassette.used = make([]bool, len(file.Interactions))
+	default:
+		return nil, errors.New("unknown cassette mode " + config.Mode)
+	}
+
+	return cassette, nil
+}
+
+// cassetteTransport records through base into a shared cassette, so
+// upstreams connecting in different ways can use the same cassette.
+type cassetteTransport struct {
+	cassette *Cassette
+	base     http.RoundTripper
+}
+
+func (t cassetteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.cassette.roundTrip(req, t.base)
+}
+
+// through returns a transport using the cassette that records requests
+// made with base.
+func (c *Cassette) through(base http.RoundTripper) http.RoundTripper {
+	return cassetteTransport{cassette: c, base: base}
+}
+
+func (c *Cassette) RoundTrip(req *http.Request) (*http.Response, error) {
+	return c.roundTrip(req, c.base)
+}
+
+func (c *Cassette) roundTrip(req *http.Request, base http.RoundTripper) (*http.Response, error) {
+	body := []byte{}
+
+	if req.Body != nil {
+		var err error
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	request := c.request(req, body)
+
+	if c.config.Mode == CassetteModeReplay {
+		return c.replay(req, request)
+	}
+
+	return c.record(req, request, base)
+}
+
+// replay answers with the first unused interaction matching request, the
+// last matching one is used again when all of them have been used so
+// repeated calls keep working.
+func (c *Cassette) replay(req *http.Request, request cassetteRequest) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found := -1
+
+	for i, interaction := range c.interactions {
+		if !c.matches(interaction.Request, request) {
+			continue
+		}
+		found = i
+		if !c.used[i] {
+			break
+		}
+	}
+
+	if found < 0 {
+		return nil, fmt.Errorf("%w %s for %s %s with body hash %s", ErrCassetteMiss, c.config.path(), request.Method, request.URL, request.BodyHash)
+	}
+
+	c.used[found] = true
+
+	return c.interactions[found].Response.httpResponse(req)
+}
+
+// record makes the request and saves the interaction, the cassette file
+// is rewritten after every interaction so nothing is lost if the server
+// is stopped.
+func (c *Cassette) record(req *http.Request, request cassetteRequest, base http.RoundTripper) (*http.Response, error) {
+	response, err := base.RoundTrip(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := cassetteResponse{StatusCode: response.StatusCode, Headers: response.Header.Clone(), Body: string(data)}
+
+	if !utf8.Valid(data) {
+		recorded.Body = base64.StdEncoding.EncodeToString(data)
+		recorded.BodyEncoding = "base64"
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.interactions = append(c.interactions, cassetteInteraction{Request: request, Response: recorded})
+
+	// A call that can not be recorded fails, returning the response along
+	// with the error would have it thrown away by the client anyway
+	if err := c.save(); err != nil {
+		response.Body.Close()
+		return nil, errors.New("could not save cassette " + c.config.path() + ", " + err.Error())
+	}
+
+	return response, nil
+}
+
+func (c *Cassette) save() error {
+	data, err := json.MarshalIndent(cassetteFile{Interactions: c.interactions}, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(c.config.path()); dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	return os.WriteFile(c.config.path(), append(data, '\n'), 0o644)
+}
+
+// request describes req as it is stored, only headers that are matched on
+// are kept so credentials do not end up in the cassette.
+func (c *Cassette) request(req *http.Request, body []byte) cassetteRequest {
+	sum := sha256.Sum256(body)
+	request := cassetteRequest{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		BodyHash: hex.EncodeToString(sum[:]),
+	}
+
+	for _, name := range c.config.MatchHeaders {
+		if values, ok := req.Header[http.CanonicalHeaderKey(name)]; ok {
+			if request.Headers == nil {
+				request.Headers = http.Header{}
+			}
+			request.Headers[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	return request
+}
+
+func (c *Cassette) matches(recorded, request cassetteRequest) bool {
+	match := c.config.Match
+	if match == nil {
+		match = defaultCassetteMatch
+	}
+
+	for _, part := range match {
+		switch {
+		case part == CassetteMatchMethod && recorded.Method != request.Method:
+			return false
+		case part == CassetteMatchURL && recorded.URL != request.URL:
+			return false
+		case part == CassetteMatchBody && recorded.BodyHash != request.BodyHash:
+			return false
+		}
+	}
+
+	for _, name := range c.config.MatchHeaders {
+		if recorded.Headers.Get(name) != request.Headers.Get(name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r cassetteResponse) httpResponse(req *http.Request) (*http.Response, error) {
+	data := []byte(r.Body)
+
+	if r.BodyEncoding == "base64" {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(r.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := r.Headers.Clone()
+	if headers == nil {
+		headers = http.Header{}
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(r.StatusCode) + " " + http.StatusText(r.StatusCode),
+		StatusCode:    r.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        headers,
+		Body:          io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+func (c CassetteConfig) path() string {
+	if c.Path == "" {
+		return defaultCassettePath
+	}
+	return c.Path
+}
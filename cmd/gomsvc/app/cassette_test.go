@@ -0,0 +1,144 @@
+package app_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func cassetteRoute(url string) app.Route {
+	return app.Route{
+		Name:   "cassette",
+		Path:   "/orders",
+		Method: http.MethodGet,
+		Upstreams: []app.Upstream{
+			{
+				URL:     url + "/orders/{{.Request.Query.Get \"id\"}}",
+				Method:  http.MethodGet,
+				Headers: map[string]string{"X-Tenant": "{{.Request.Query.Get \"tenant\"}}"},
+			},
+			{
+				URL:     url + "/prices",
+				Method:  http.MethodPost,
+				Headers: map[string]string{"content-type": "application/json"},
+				Body:    map[string]interface{}{"id": "{{.Request.Query.Get \"id\"}}"},
+			},
+		},
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Mappings: []app.Mapping{
+				{Target: "order", Source: "$.upstreams[0].body"},
+				{Target: "price", Source: "$.upstreams[1].body.price"},
+			},
+		},
+	}
+}
+
+func TestThatCassettesReplayRecordedUpstreamCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		w.Header().Set("content-type", "application/json")
+		if r.URL.Path == "/prices" {
+			var body map[string]string
+			json.Unmarshal(data, &body)
+			w.Write([]byte(`{"price": "` + body["id"] + `0"}`))
+			return
+		}
+		w.Write([]byte(`{"path": "` + r.URL.Path + `", "tenant": "` + r.Header.Get("X-Tenant") + `"}`))
+	}))
+
+	path := filepath.Join(t.TempDir(), "cassettes", "orders.json")
+	route := cassetteRoute(server.URL)
+
+	record := app.Config{Cassette: &app.CassetteConfig{Mode: app.CassetteModeRecord, Path: path, MatchHeaders: []string{"x-tenant"}}}
+	live := map[string]string{}
+	for _, target := range []string{"/orders?id=1&tenant=a", "/orders?id=1&tenant=b", "/orders?id=2&tenant=a"} {
+		w := httptest.NewRecorder()
+		app.MakeHandlerFunc(route, record, testLogger)(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		live[target] = w.Body.String()
+	}
+
+	server.Close()
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var file map[string][]map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &file))
+	assert.Len(t, file["interactions"], 6)
+
+	replay := app.Config{Cassette: &app.CassetteConfig{Mode: app.CassetteModeReplay, Path: path, MatchHeaders: []string{"x-tenant"}}}
+	for target, body := range live {
+		w := httptest.NewRecorder()
+		app.MakeHandlerFunc(route, replay, testLogger)(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, body, w.Body.String(), target)
+	}
+
+	// Requests that were never recorded fail loudly instead of reaching out
+	log := &recordingLogger{}
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, replay, log)(w, httptest.NewRequest(http.MethodGet, "/orders?id=2&tenant=b", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "no recorded interaction in cassette "+path+" for GET "+server.URL+"/orders/2")
+	assert.True(t, log.contains("no recorded interaction in cassette"))
+}
+
+func TestThatCassettesCanMatchOnlyOnMethodAndURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	os.WriteFile(path, []byte(`{"interactions": [
+		{"request": {"method": "GET", "url": "http://upstream.invalid/orders/1", "body_hash": "ignored"}, "response": {"status_code": 200, "headers": {"Content-Type": ["application/json"]}, "body": "{\"id\": 1}"}},
+		{"request": {"method": "POST", "url": "http://upstream.invalid/prices", "body_hash": "ignored"}, "response": {"status_code": 200, "headers": {"Content-Type": ["application/json"]}, "body": "{\"price\": \"10\"}"}}
+	]}`), 0o644)
+
+	config := app.Config{Cassette: &app.CassetteConfig{
+		Mode:  app.CassetteModeReplay,
+		Path:  path,
+		Match: []string{app.CassetteMatchMethod, app.CassetteMatchURL},
+	}}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(cassetteRoute("http://upstream.invalid"), config, testLogger)(w, httptest.NewRequest(http.MethodGet, "/orders?id=1", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"order": {"id": 1}, "price": "10"}`, w.Body.String())
+}
+
+func TestThatMissingCassetteFailsUpstreamRoutes(t *testing.T) {
+	config := app.Config{Cassette: &app.CassetteConfig{Mode: app.CassetteModeReplay, Path: filepath.Join(t.TempDir(), "missing.json")}}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(cassetteRoute("http://upstream.invalid"), config, testLogger)(w, httptest.NewRequest(http.MethodGet, "/orders?id=1", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "could not load cassette")
+}
+
+func TestThatCassettesThatCanNotBeSavedFailTheCall(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// The cassette directory is a file so the cassette can not be written
+	file := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(file, nil, 0o644)
+
+	cassette, err := app.NewCassette(app.CassetteConfig{Mode: app.CassetteModeRecord, Path: filepath.Join(file, "cassette.json")}, http.DefaultTransport)
+	assert.NoError(t, err)
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	response, err := cassette.RoundTrip(request)
+
+	assert.Nil(t, response)
+	assert.ErrorContains(t, err, "could not save cassette")
+}
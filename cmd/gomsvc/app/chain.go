@@ -0,0 +1,118 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+)
+
+// templateUpstream is what templates see of an upstream that was called
+// before the one being rendered.
+type templateUpstream struct {
+	URL        string
+	StatusCode int
+	Headers    http.Header
+	Body       interface{}
+	Error      string
+	Skipped    bool
+}
+
+// newTemplateUpstreams describes results for templates, JSON bodies are
+// decoded so their fields can be used directly.
+func newTemplateUpstreams(results []upstreamResult) []templateUpstream {
+	upstreams := make([]templateUpstream, len(results))
+
+	for i, result := range results {
+		upstream := templateUpstream{Skipped: result.skipped}
+		switch {
+		case result.skipped:
+		case result.err != nil:
+			upstream.URL = result.url()
+			upstream.Error = result.err.Error()
+		default:
+			upstream.URL = result.url()
+			upstream.StatusCode = result.response.StatusCode
+			upstream.Headers = result.response.Header
+			data, _ := upstreamBody(result.response)
+			upstream.Body = string(data)
+			if httptools.IsJSON(result.response.Header) {
+				var container interface{}
+				if err := json.Unmarshal(data, &container); err == nil {
+					upstream.Body = container
+				}
+			}
+		}
+		upstreams[i] = upstream
+	}
+
+	return upstreams
+}
+
+// chained tells if the upstream uses the results of earlier upstreams,
+// which means it has to wait for all of them to finish before it is called.
+func (u Upstream) chained() bool {
+	texts := []string{u.URL, u.If}
+	for _, v := range u.Headers {
+		texts = append(texts, v)
+	}
+	if body, err := json.Marshal(u.Body); err == nil {
+		texts = append(texts, string(body))
+	}
+	for _, text := range texts {
+		if strings.Contains(text, ".Upstreams") {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare renders the condition, URL, headers and body of the upstream with
+// data, or takes the body from the incoming request when it is forwarded.
+// The returned bool is false when the condition says to skip it.
+func (u Upstream) prepare(data templateData) (Upstream, bool, error) {
+	if u.If != "" {
+		condition, err := renderTemplate(u.If, data)
+		if err != nil {
+			return u, false, err
+		}
+		switch strings.ToLower(strings.TrimSpace(condition)) {
+		case "", "false", "0", "no", "<no value>":
+			return u, false, nil
+		}
+	}
+
+	url, err := renderTemplate(u.URL, data)
+
+	if err != nil {
+		return u, false, err
+	}
+
+	headers := make(map[string]string, len(u.Headers))
+	for k, v := range u.Headers {
+		if headers[k], err = renderTemplate(v, data); err != nil {
+			return u, false, err
+		}
+	}
+
+	body, err := renderTemplateValues(u.Body, data)
+
+	if err != nil {
+		return u, false, err
+	}
+
+	u.configuredURL = u.URL
+	u.URL = url
+	u.Headers = headers
+	u.Body = body
+
+	if u.ForwardRequestBody {
+		u.Body = forwardedBody(data.Request.body)
+		if _, ok := u.header("Content-Type"); !ok && data.Request.Headers.Get("Content-Type") != "" {
+			u.Headers["Content-Type"] = data.Request.Headers.Get("Content-Type")
+		}
+	}
+
+	return u, true, nil
+}
@@ -0,0 +1,114 @@
+package app_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatUpstreamsCanBeChained(t *testing.T) {
+	var audited, alerted int32
+	var auditBody map[string]interface{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(`{"token": "abc"}`))
+	})
+	mux.HandleFunc("/api/users/7", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(`{"id": 42}`))
+	})
+	mux.HandleFunc("/audit", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&audited, 1)
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &auditBody)
+	})
+	mux.HandleFunc("/alert", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&alerted, 1)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	route := app.Route{
+		Name:   "chain",
+		Path:   "/users/7",
+		Method: http.MethodGet,
+		Upstreams: []app.Upstream{
+			{URL: server.URL + "/token", Method: http.MethodGet},
+			{
+				URL:     server.URL + "/api{{.Request.Path}}",
+				Method:  http.MethodGet,
+				Headers: map[string]string{"Authorization": "Bearer {{(index .Upstreams 0).Body.token}}"},
+			},
+			{
+				URL:     server.URL + "/audit",
+				Method:  http.MethodPost,
+				If:      "{{eq (index .Upstreams 1).StatusCode 200}}",
+				Headers: map[string]string{"content-type": "application/json"},
+				Body:    map[string]interface{}{"id": "{{(index .Upstreams 1).Body.id}}", "by": "{{.Request.Query.Get \"user\"}}"},
+			},
+			{
+				URL:    server.URL + "/alert",
+				Method: http.MethodPost,
+				If:     "{{ne (index .Upstreams 1).StatusCode 200}}",
+			},
+		},
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Mappings: []app.Mapping{
+				{Target: "id", Source: "$.upstreams[1].body.id"},
+				{Target: "alerted", Source: "$.upstreams[3].skipped"},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/users/7?user=jane", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"id": 42, "alerted": true}`, w.Body.String())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&audited))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&alerted))
+	assert.Equal(t, map[string]interface{}{"id": "42", "by": "jane"}, auditBody)
+}
+
+func TestThatUpstreamConditionsCanUseTheRequest(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+
+	route := app.Route{
+		Name:      "conditional",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{{URL: server.URL, Method: http.MethodGet, If: `{{.Request.Query.Get "notify"}}`}},
+		Response: app.Response{
+			StatusCode:               http.StatusOK,
+			Headers:                  map[string]string{"content-type": "application/json"},
+			IncludeUpstreamResponses: true,
+		},
+	}
+	handler := app.MakeHandlerFunc(route, app.Config{}, testLogger)
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/?notify=false", nil))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+	assert.Contains(t, w.Body.String(), `"skipped": true`)
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/?notify=yes", nil))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
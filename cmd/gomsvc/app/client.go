@@ -0,0 +1,205 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// transports holds the transport of every client profile in use, keyed by
+// the profile itself so upstreams with the same profile share connections.
+var transports sync.Map
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ClientProfile configures how upstreams are connected to. CACert, ClientCert
+// and ClientKey are either paths to PEM files or PEM data. The CA bundle
+// replaces the system roots when it is set.
+type ClientProfile struct {
+	CACert             string `json:"ca_cert"`
+	ClientCert         string `json:"client_cert"`
+	ClientKey          string `json:"client_key"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+	ServerName         string `json:"server_name"`
+	MinVersion         string `json:"min_version"`
+	DisableHTTP2       bool   `json:"disable_http2"`
+}
+
+// UpstreamClient is the client profile of an upstream, configured either as
+// the name of a profile in the clients of the configuration or as a profile
+// of its own.
+type UpstreamClient struct {
+	Name    string
+	Profile *ClientProfile
+}
+
+func (c UpstreamClient) MarshalJSON() ([]byte, error) {
+	if c.Profile != nil {
+		return json.Marshal(c.Profile)
+	}
+	return json.Marshal(c.Name)
+}
+
+func (c *UpstreamClient) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.Name); err == nil {
+		c.Profile = nil
+		return nil
+	}
+
+	var profile ClientProfile
+
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return errors.New("upstream client has to be a profile name or a profile, " + err.Error())
+	}
+
+	c.Name = ""
+	c.Profile = &profile
+
+	return nil
+}
+
+// upstreamClients returns the client to call an upstream with.
+type upstreamClients func(upstream Upstream) (*http.Client, error)
+
+// upstreamClient returns the client upstream is called with, which uses
+// the client profile of the upstream and goes through a cassette when one
+// is configured.
+func upstreamClient(config Config, upstream Upstream) (*http.Client, error) {
+	profile, err := config.clientProfile(upstream)
+
+	if err != nil {
+		return nil, err
+	}
+
+	base := http.DefaultTransport
+
+	if profile != nil {
+		if base, err = profile.transport(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Cassette != nil && config.Cassette.Mode != "" {
+		cassette, err := cassettes.get(*config.Cassette)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: cassette.through(base)}, nil
+	}
+
+	if profile == nil {
+		return http.DefaultClient, nil
+	}
+
+	return &http.Client{Transport: base}, nil
+}
+
+// clientProfile returns the client profile of upstream, nil means the
+// default client is used.
+func (c Config) clientProfile(upstream Upstream) (*ClientProfile, error) {
+	if upstream.Client == nil {
+		return nil, nil
+	}
+
+	if upstream.Client.Profile != nil {
+		return upstream.Client.Profile, nil
+	}
+
+	profile, ok := c.Clients[upstream.Client.Name]
+
+	if !ok {
+		return nil, errors.New("unknown client profile " + upstream.Client.Name + " for upstream " + upstream.URL)
+	}
+
+	return &profile, nil
+}
+
+// transport returns the transport for the profile, creating it the first
+// time the profile is used.
+func (p ClientProfile) transport() (http.RoundTripper, error) {
+	if cached, ok := transports.Load(p); ok {
+		return cached.(http.RoundTripper), nil
+	}
+
+	config, err := p.tlsConfig()
+
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+
+	if p.DisableHTTP2 {
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	cached, _ := transports.LoadOrStore(p, transport)
+
+	return cached.(http.RoundTripper), nil
+}
+
+func (p ClientProfile) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: p.InsecureSkipVerify,
+		ServerName:         p.ServerName,
+	}
+
+	if p.MinVersion != "" {
+		version, ok := tlsVersions[p.MinVersion]
+		if !ok {
+			return nil, errors.New("unknown minimum TLS version " + p.MinVersion + ", use one of 1.0, 1.1, 1.2 or 1.3")
+		}
+		config.MinVersion = version
+	}
+
+	if p.CACert != "" {
+		data, err := pemData(p.CACert)
+		if err != nil {
+			return nil, errors.New("could not read CA bundle, " + err.Error())
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates found in CA bundle " + p.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	if p.ClientCert != "" || p.ClientKey != "" {
+		cert, err := pemData(p.ClientCert)
+		if err != nil {
+			return nil, errors.New("could not read client certificate, " + err.Error())
+		}
+		key, err := pemData(p.ClientKey)
+		if err != nil {
+			return nil, errors.New("could not read client key, " + err.Error())
+		}
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, errors.New("could not load client certificate, " + err.Error())
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+
+	return config, nil
+}
+
+// pemData returns value when it is PEM data and otherwise reads the file
+// it points at.
+func pemData(value string) ([]byte, error) {
+	if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+		return []byte(value), nil
+	}
+	return os.ReadFile(value)
+}
@@ -0,0 +1,216 @@
+package app_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func tlsServer(t *testing.T, configure func(server *httptest.Server)) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client := ""
+		if len(r.TLS.PeerCertificates) > 0 {
+			client = r.TLS.PeerCertificates[0].Subject.CommonName
+		}
+		w.Header().Set("content-type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"proto": r.Proto, "client": client})
+	}))
+	if configure != nil {
+		configure(server)
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// serverCA writes the certificate of server to a PEM file and returns its
+// path, the certificate is valid for example.com and 127.0.0.1.
+func serverCA(t *testing.T, server *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(path, data, 0o644))
+	return path
+}
+
+func clientCertificate(t *testing.T, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+// callThrough calls url from a route with client as the client of the
+// upstream and returns what the upstream answered or the error of the call.
+func callThrough(t *testing.T, config app.Config, url string, client *app.UpstreamClient) (map[string]interface{}, string) {
+	route := app.Route{
+		Name:      "tls",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{{URL: url, Method: http.MethodGet, Client: client}},
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Mappings: []app.Mapping{
+				{Target: "body", Source: "$.upstreams[0].body"},
+				{Target: "error", Source: "$.upstreams[0].error", Default: ""},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, config, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var body struct {
+		Body  map[string]interface{} `json:"body"`
+		Error string                 `json:"error"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+
+	return body.Body, body.Error
+}
+
+func TestThatUpstreamsTrustConfiguredCertificateAuthorities(t *testing.T) {
+	server := tlsServer(t, nil)
+	ca := serverCA(t, server)
+
+	_, err := callThrough(t, app.Config{}, server.URL, nil)
+	assert.Contains(t, err, "certificate")
+
+	body, err := callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{CACert: ca}})
+	assert.Empty(t, err)
+	assert.Equal(t, "", body["client"])
+
+	caData, _ := os.ReadFile(ca)
+	_, err = callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{CACert: string(caData)}})
+	assert.Empty(t, err)
+
+	_, err = callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{InsecureSkipVerify: true}})
+	assert.Empty(t, err)
+
+	// The certificate is valid for example.com but not for other names
+	_, err = callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{CACert: ca, ServerName: "example.com"}})
+	assert.Empty(t, err)
+
+	_, err = callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{CACert: ca, ServerName: "other.test"}})
+	assert.Contains(t, err, "other.test")
+}
+
+func TestThatUpstreamsPresentClientCertificates(t *testing.T) {
+	server := tlsServer(t, func(server *httptest.Server) {
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	})
+	cert, key := clientCertificate(t, "gomsvc")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "client.pem"), []byte(cert), 0o644)
+	os.WriteFile(filepath.Join(dir, "client-key.pem"), []byte(key), 0o600)
+
+	config := app.Config{Clients: map[string]app.ClientProfile{
+		"internal": {
+			CACert:     serverCA(t, server),
+			ClientCert: filepath.Join(dir, "client.pem"),
+			ClientKey:  filepath.Join(dir, "client-key.pem"),
+		},
+	}}
+
+	_, err := callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{InsecureSkipVerify: true}})
+	assert.NotEmpty(t, err)
+
+	body, err := callThrough(t, config, server.URL, &app.UpstreamClient{Name: "internal"})
+	assert.Empty(t, err)
+	assert.Equal(t, "gomsvc", body["client"])
+
+	body, err = callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{InsecureSkipVerify: true, ClientCert: cert, ClientKey: key}})
+	assert.Empty(t, err)
+	assert.Equal(t, "gomsvc", body["client"])
+}
+
+func TestThatClientProfilesControlProtocolVersions(t *testing.T) {
+	server := tlsServer(t, func(server *httptest.Server) {
+		server.EnableHTTP2 = true
+	})
+	ca := serverCA(t, server)
+
+	body, err := callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{CACert: ca}})
+	assert.Empty(t, err)
+	assert.Equal(t, "HTTP/2.0", body["proto"])
+
+	body, err = callThrough(t, app.Config{}, server.URL, &app.UpstreamClient{Profile: &app.ClientProfile{CACert: ca, DisableHTTP2: true}})
+	assert.Empty(t, err)
+	assert.Equal(t, "HTTP/1.1", body["proto"])
+
+	legacy := tlsServer(t, func(server *httptest.Server) {
+		server.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	})
+
+	_, err = callThrough(t, app.Config{}, legacy.URL, &app.UpstreamClient{Profile: &app.ClientProfile{InsecureSkipVerify: true, MinVersion: "1.2"}})
+	assert.Empty(t, err)
+
+	_, err = callThrough(t, app.Config{}, legacy.URL, &app.UpstreamClient{Profile: &app.ClientProfile{InsecureSkipVerify: true, MinVersion: "1.3"}})
+	assert.Contains(t, err, "version")
+}
+
+func TestThatInvalidClientProfilesFailTheRoute(t *testing.T) {
+	for name, client := range map[string]*app.UpstreamClient{
+		"unknown client profile missing": {Name: "missing"},
+		"could not read CA bundle":       {Profile: &app.ClientProfile{CACert: filepath.Join(t.TempDir(), "missing.pem")}},
+		"unknown minimum TLS version":    {Profile: &app.ClientProfile{MinVersion: "2.0"}},
+	} {
+		route := app.Route{
+			Name:      "tls",
+			Path:      "/",
+			Method:    http.MethodGet,
+			Upstreams: []app.Upstream{{URL: "https://upstream.invalid", Method: http.MethodGet, Client: client}},
+			Response:  app.Response{StatusCode: http.StatusOK},
+		}
+
+		w := httptest.NewRecorder()
+		app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code, name)
+		assert.Contains(t, w.Body.String(), name)
+	}
+}
+
+func TestThatUpstreamClientsAreReadAsNamesOrProfiles(t *testing.T) {
+	config, err := app.ConfigFromReader(strings.NewReader(`{
+		"clients": {"internal": {"ca_cert": "ca.pem", "min_version": "1.2"}},
+		"routes": [{"upstreams": [
+			{"url": "https://a", "client": "internal"},
+			{"url": "https://b", "client": {"insecure_skip_verify": true, "disable_http2": true}}
+		]}]
+	}`))
+	assert.NoError(t, err)
+
+	assert.Equal(t, app.ClientProfile{CACert: "ca.pem", MinVersion: "1.2"}, config.Clients["internal"])
+	assert.Equal(t, &app.UpstreamClient{Name: "internal"}, config.Routes[0].Upstreams[0].Client)
+	assert.Equal(t, &app.UpstreamClient{Profile: &app.ClientProfile{InsecureSkipVerify: true, DisableHTTP2: true}}, config.Routes[0].Upstreams[1].Client)
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+)
+
+var defaultCompressionEncodings = []string{
+	httptools.EncodingBrotli,
+	httptools.EncodingGzip,
+	httptools.EncodingDeflate,
+}
+
+type Compression struct {
+	Encodings       []string `json:"encodings"`
+	MinSize         int      `json:"min_size"`
+	ContentTypes    []string `json:"content_types"`
+	Precompressed   string   `json:"precompressed"`
+	Force           string   `json:"force"`
+	ContentEncoding string   `json:"content_encoding"`
+}
+
+// Apply encodes data according to the compression settings and the
+// Accept-Encoding header of the request. The returned string is the value
+// to use for the Content-Encoding header, empty when data was left as is.
+func (c *Compression) Apply(request *http.Request, headers http.Header, data []byte) ([]byte, string, error) {
+	if c == nil {
+		return data, "", nil
+	}
+
+	// A content encoding set explicitly is sent without touching the body
+	// so clients can be tested against mislabeled or unknown encodings
+	if c.ContentEncoding != "" {
+		return data, c.ContentEncoding, nil
+	}
+
+	if c.Precompressed != "" {
+		return data, c.Precompressed, nil
+	}
+
+	if c.Force != "" {
+		encoded, err := httptools.Encode(c.Force, data)
+		if err != nil {
+			return data, "", err
+		}
+		return encoded, c.Force, nil
+	}
+
+	if len(data) < c.MinSize || !c.allowsContentType(headers.Get("content-type")) {
+		return data, "", nil
+	}
+
+	encoding := httptools.NegotiateEncoding(request.Header.Get("Accept-Encoding"), c.encodings())
+
+	if encoding == "" {
+		return data, "", nil
+	}
+
+	encoded, err := httptools.Encode(encoding, data)
+
+	if err != nil {
+		return data, "", err
+	}
+
+	return encoded, encoding, nil
+}
+
+// precompressedContentType returns the content type of a precompressed
+// file: body from its name without the extension of the encoding, so
+// app.js.gz is served as JavaScript.
+func (r Response) precompressedContentType() string {
+	name := strings.TrimSuffix(r.filePath(), filepath.Ext(r.filePath()))
+	if contentType := mime.TypeByExtension(filepath.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return "application/octet-stream"
+}
+
+func (c *Compression) encodings() []string {
+	encodings := []string{}
+	if len(c.Encodings) == 0 {
+		return defaultCompressionEncodings
+	}
+	for _, encoding := range c.Encodings {
+		if httptools.IsSupportedEncoding(encoding) {
+			encodings = append(encodings, encoding)
+		}
+	}
+	return encodings
+}
+
+func (c *Compression) allowsContentType(contentType string) bool {
+	if len(c.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	if err != nil {
+		mediaType = "text/plain"
+	}
+
+	for _, allowed := range c.ContentTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mediaType || (strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	etagAuto         = "auto"
+	etagWeak         = "weak"
+	lastModifiedFile = "file"
+)
+
+// computesETag reports whether the ETag is derived from the rendered
+// response, in which case preconditions can only be evaluated after it has
+// been rendered.
+func (r Response) computesETag() bool {
+	return r.ETag == etagAuto || r.ETag == etagWeak
+}
+
+// entityTag returns the quoted ETag for the response, either hashed from
+// data or taken as is from the configuration.
+func (r Response) entityTag(data []byte) string {
+	switch r.ETag {
+	case "":
+		return ""
+	case etagAuto, etagWeak:
+		sum := sha256.Sum256(data)
+		tag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		if r.ETag == etagWeak {
+			return "W/" + tag
+		}
+		return tag
+	}
+	if strings.HasPrefix(r.ETag, `"`) || strings.HasPrefix(r.ETag, `W/"`) {
+		return r.ETag
+	}
+	return `"` + r.ETag + `"`
+}
+
+// lastModified parses the configured Last-Modified time, file uses the
+// modification time of a file: body.
+func (r Response) lastModified() (time.Time, bool) {
+	if r.LastModified == "" {
+		return time.Time{}, false
+	}
+
+	if r.LastModified == lastModifiedFile {
+		body, ok := r.Body.(string)
+		if !ok || !strings.HasPrefix(body, "file:") {
+			return time.Time{}, false
+		}
+		info, err := os.Stat(strings.TrimPrefix(body, "file:"))
+		if err != nil {
+			return time.Time{}, false
+		}
+		return info.ModTime().UTC().Truncate(time.Second), true
+	}
+
+	if t, err := http.ParseTime(r.LastModified); err == nil {
+		return t, true
+	}
+
+	if t, err := time.Parse(time.RFC3339, r.LastModified); err == nil {
+		return t.UTC().Truncate(time.Second), true
+	}
+
+	return time.Time{}, false
+}
+
+// setValidators adds the caching related headers that are configured for
+// the response, they are sent with 304 responses as well.
+func (r Response) setValidators(header http.Header, data []byte) {
+	if etag := r.entityTag(data); etag != "" {
+		header.Set("ETag", etag)
+	}
+	if lastModified, ok := r.lastModified(); ok {
+		header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+	}
+	if r.CacheControl != "" {
+		header.Set("Cache-Control", r.CacheControl)
+	}
+}
+
+// precondition evaluates the conditional headers of the request against
+// the validators of the response rendered as data. Responses without any
+// validators configured ignore conditional requests, except for an
+// If-Match: * which fails when there is no representation to match.
+func (r Response) precondition(request *http.Request, data []byte) int {
+	if r.ETag == "" && r.LastModified == "" {
+		if strings.TrimSpace(request.Header.Get("If-Match")) == "*" && !r.hasRepresentation() {
+			return http.StatusPreconditionFailed
+		}
+		return 0
+	}
+	lastModified, _ := r.lastModified()
+	return checkPreconditions(request, r.entityTag(data), lastModified)
+}
+
+// hasRepresentation reports whether the response serves a current
+// representation, which takes a successful status and something to send.
+func (r Response) hasRepresentation() bool {
+	if r.StatusCode != 0 && (r.StatusCode < 200 || r.StatusCode > 299) {
+		return false
+	}
+	return r.Body != nil || r.IncludeUpstreamResponses || r.IncludeRequestInformation
+}
+
+// checkPreconditions evaluates the conditional headers of the request in
+// the order given by RFC 9110 and returns 304 or 412 when the request should
+// be answered with either of them, 0 otherwise.
+func checkPreconditions(request *http.Request, etag string, lastModified time.Time) int {
+	safe := request.Method == http.MethodGet || request.Method == http.MethodHead
+
+	if ifMatch := request.Header.Get("If-Match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(request.Header.Get("If-Unmodified-Since")); err == nil && !lastModified.IsZero() {
+		if lastModified.After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, etag, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(request.Header.Get("If-Modified-Since")); err == nil && safe && !lastModified.IsZero() {
+		if !lastModified.After(since) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// etagListMatches compares etag with a list of entity tags from If-Match
+// or If-None-Match, using strong comparison for If-Match.
+func etagListMatches(list string, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	if etag == "" || (strong && strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
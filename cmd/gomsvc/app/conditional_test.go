@@ -0,0 +1,186 @@
+package app_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatHandlerAnswersConditionalRequests(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		response app.Response
+		headers  map[string]string
+		status   int
+		etag     string
+	}{
+		{
+			name:     "configured etag matches if-none-match",
+			method:   http.MethodGet,
+			response: app.Response{ETag: "v1"},
+			headers:  map[string]string{"If-None-Match": `"v0", "v1"`},
+			status:   http.StatusNotModified,
+			etag:     `"v1"`,
+		},
+		{
+			name:     "configured etag does not match if-none-match",
+			method:   http.MethodGet,
+			response: app.Response{ETag: "v1"},
+			headers:  map[string]string{"If-None-Match": `"v0"`},
+			status:   http.StatusOK,
+			etag:     `"v1"`,
+		},
+		{
+			name:     "weak etag matches if-none-match",
+			method:   http.MethodGet,
+			response: app.Response{ETag: `W/"v1"`},
+			headers:  map[string]string{"If-None-Match": `"v1"`},
+			status:   http.StatusNotModified,
+			etag:     `W/"v1"`,
+		},
+		{
+			name:     "computed etag matches if-none-match",
+			method:   http.MethodGet,
+			response: app.Response{ETag: "auto"},
+			headers:  map[string]string{"If-None-Match": `"2cf24dba5fb0a30e"`},
+			status:   http.StatusNotModified,
+			etag:     `"2cf24dba5fb0a30e"`,
+		},
+		{
+			name:     "computed weak etag",
+			method:   http.MethodGet,
+			response: app.Response{ETag: "weak"},
+			headers:  map[string]string{},
+			status:   http.StatusOK,
+			etag:     `W/"2cf24dba5fb0a30e"`,
+		},
+		{
+			name:     "not modified since",
+			method:   http.MethodGet,
+			response: app.Response{LastModified: "2023-01-01T00:00:00Z"},
+			headers:  map[string]string{"If-Modified-Since": "Sun, 01 Jan 2023 00:00:00 GMT"},
+			status:   http.StatusNotModified,
+		},
+		{
+			name:     "modified since",
+			method:   http.MethodGet,
+			response: app.Response{LastModified: "2023-01-02T00:00:00Z"},
+			headers:  map[string]string{"If-Modified-Since": "Sun, 01 Jan 2023 00:00:00 GMT"},
+			status:   http.StatusOK,
+		},
+		{
+			name:     "if-none-match takes precedence over if-modified-since",
+			method:   http.MethodGet,
+			response: app.Response{ETag: "v1", LastModified: "2023-01-01T00:00:00Z"},
+			headers:  map[string]string{"If-None-Match": `"v0"`, "If-Modified-Since": "Sun, 01 Jan 2023 00:00:00 GMT"},
+			status:   http.StatusOK,
+			etag:     `"v1"`,
+		},
+		{
+			name:     "conditional put with matching etag",
+			method:   http.MethodPut,
+			response: app.Response{ETag: "v1"},
+			headers:  map[string]string{"If-Match": `"v1"`},
+			status:   http.StatusOK,
+			etag:     `"v1"`,
+		},
+		{
+			name:     "conditional put with stale etag",
+			method:   http.MethodPut,
+			response: app.Response{ETag: "v2"},
+			headers:  map[string]string{"If-Match": `"v1"`},
+			status:   http.StatusPreconditionFailed,
+			etag:     `"v2"`,
+		},
+		{
+			name:     "conditional put with weak etag fails strong comparison",
+			method:   http.MethodPut,
+			response: app.Response{ETag: `W/"v1"`},
+			headers:  map[string]string{"If-Match": `W/"v1"`},
+			status:   http.StatusPreconditionFailed,
+			etag:     `W/"v1"`,
+		},
+		{
+			name:     "conditional put that must not overwrite",
+			method:   http.MethodPut,
+			response: app.Response{ETag: "v1"},
+			headers:  map[string]string{"If-None-Match": "*"},
+			status:   http.StatusPreconditionFailed,
+			etag:     `"v1"`,
+		},
+		{
+			name:     "conditional put modified since",
+			method:   http.MethodPut,
+			response: app.Response{LastModified: "2023-01-02T00:00:00Z"},
+			headers:  map[string]string{"If-Unmodified-Since": "Sun, 01 Jan 2023 00:00:00 GMT"},
+			status:   http.StatusPreconditionFailed,
+		},
+		{
+			name:     "if-match any with representation",
+			method:   http.MethodPut,
+			response: app.Response{},
+			headers:  map[string]string{"If-Match": "*"},
+			status:   http.StatusOK,
+		},
+		{
+			name:     "no validators configured",
+			method:   http.MethodPut,
+			response: app.Response{},
+			headers:  map[string]string{"If-Match": `"v1"`},
+			status:   http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := tt.response
+			response.StatusCode = http.StatusOK
+			response.Body = "hello"
+			response.CacheControl = "max-age=60"
+			route := app.Route{
+				Name:     tt.name,
+				Path:     "/",
+				Method:   tt.method,
+				Response: response,
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.etag, w.Header().Get("ETag"))
+			assert.Equal(t, "max-age=60", w.Header().Get("Cache-Control"))
+			if tt.status != http.StatusOK {
+				assert.Empty(t, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestThatIfMatchAnyFailsWithoutRepresentation(t *testing.T) {
+	responses := []app.Response{
+		{StatusCode: http.StatusCreated},
+		{StatusCode: http.StatusNotFound, Body: "not found"},
+	}
+
+	for _, response := range responses {
+		route := app.Route{Name: "create", Path: "/", Method: http.MethodPut, Response: response}
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, "/", nil)
+		r.Header.Set("If-Match", "*")
+
+		app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, r)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	}
+}
@@ -6,11 +6,21 @@ import (
 	"errors"
 	"io"
 	"os"
+	"time"
 )
 
 type Config struct {
-	Port   string  `json:"port"`
-	Routes []Route `json:"routes"`
+	Port     string                   `json:"port"`
+	Routes   []Route                  `json:"routes"`
+	CORS     *CORS                    `json:"cors"`
+	Session  *SessionConfig           `json:"session"`
+	Admin    *AdminConfig             `json:"admin"`
+	Record   *RecordConfig            `json:"record"`
+	Cassette *CassetteConfig          `json:"cassette"`
+	Clients  map[string]ClientProfile `json:"clients"`
+	// ShutdownTimeout bounds how long shutdown waits for requests and
+	// pending callbacks to finish
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
 }
 
 func (c Config) Address() string {
@@ -21,6 +31,13 @@ func (c Config) Address() string {
 	return ":" + port
 }
 
+func (c Config) shutdownTimeout() time.Duration {
+	if c.ShutdownTimeout.Duration <= 0 {
+		return defaultShutdownTimeout
+	}
+	return c.ShutdownTimeout.Duration
+}
+
 func ConfigFromFilePath(path string) (Config, error) {
 	var config Config
 
@@ -63,6 +80,11 @@ func LoadRoutesFromDir() ([]Route, error) {
 			return nil, err
 		}
 		for _, file := range entries {
+			// Bodies written by the recorder are referenced by the routes
+			// and are not routes themselves
+			if file.IsDir() && file.Name() == recordBodiesDir {
+				continue
+			}
 			data, err := os.ReadFile(dir + "/" + file.Name())
 			if err != nil {
 				return nil, err
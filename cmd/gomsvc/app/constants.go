@@ -4,8 +4,14 @@ const (
 	envKeyConfigPath   = "GOMSVC_CONFIG_PATH"
 	envKeyConfigString = "GOMSVC_CONFIG_STRING"
 	envKeyRoutesDir    = "GOMSVC_ROUTES_DIR"
+	envKeyRecordTarget = "GOMSVC_RECORD_TARGET"
+	envKeyCassetteMode = "GOMSVC_CASSETTE_MODE"
+	envKeyCassettePath = "GOMSVC_CASSETTE_PATH"
 	configPathDefault  = "config.json"
 	defaultPort        = "8080"
+	defaultXMLRoot     = "response"
+	defaultAdminPath   = "/_gomsvc"
+	wrappedBodyKey     = "body"
 
 	httpHeaderAddRequestHeadersInResponse = "X-GOMSVC-Add-Request-Headers-In-Response"
 	httpHeaderAddUpstreamsInResponse      = "X-GOMSVC-Add-Upstreams-In-Response"
@@ -0,0 +1,64 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Cookie struct {
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Path     string `json:"path"`
+	Domain   string `json:"domain"`
+	Expires  string `json:"expires"`
+	MaxAge   int    `json:"max_age"`
+	SameSite string `json:"same_site"`
+	HTTPOnly bool   `json:"http_only"`
+	Secure   bool   `json:"secure"`
+}
+
+// HTTPCookie turns the configuration into a http.Cookie, the value is
+// rendered as a template with data.
+func (c Cookie) HTTPCookie(data templateData) (*http.Cookie, error) {
+	value, err := renderTemplate(c.Value, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cookie := &http.Cookie{
+		Name:     c.Name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		MaxAge:   c.MaxAge,
+		HttpOnly: c.HTTPOnly,
+		Secure:   c.Secure,
+		SameSite: sameSite(c.SameSite),
+	}
+
+	if c.Expires != "" {
+		if expires, err := http.ParseTime(c.Expires); err == nil {
+			cookie.Expires = expires
+		} else if expires, err := time.Parse(time.RFC3339, c.Expires); err == nil {
+			cookie.Expires = expires
+		} else if duration, err := time.ParseDuration(c.Expires); err == nil {
+			cookie.Expires = time.Now().Add(duration)
+		}
+	}
+
+	return cookie, nil
+}
+
+func sameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
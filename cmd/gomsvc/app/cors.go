@@ -0,0 +1,110 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type CORS struct {
+	Disabled         bool     `json:"disabled"`
+	AllowedOrigins   []string `json:"allowed_origins"`
+	ReflectOrigin    bool     `json:"reflect_origin"`
+	AllowedMethods   []string `json:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"`
+}
+
+// corsFor returns the CORS settings that apply to route, settings on the
+// route replaces the ones in the configuration.
+func corsFor(route Route, config Config) *CORS {
+	cors := config.CORS
+	if route.CORS != nil {
+		cors = route.CORS
+	}
+	if cors == nil || cors.Disabled {
+		return nil
+	}
+	return cors
+}
+
+// isPreflight reports whether the request is a CORS preflight request.
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// Preflight answers a preflight request, methods is what is registered for
+// the path and is used unless allowed methods are configured.
+func (c CORS) Preflight(w http.ResponseWriter, r *http.Request, methods []string) {
+	if !c.setOrigin(w.Header(), r.Header.Get("Origin")) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	allowedMethods := c.AllowedMethods
+	if len(allowedMethods) == 0 {
+		allowedMethods = methods
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(allowedMethods, ", "))
+
+	// Without configured headers whatever the browser asks for is allowed
+	if len(c.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		w.Header().Set("Access-Control-Allow-Headers", requested)
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetHeaders adds the CORS headers for an actual, non preflight, request.
+func (c CORS) SetHeaders(header http.Header, origin string) {
+	if origin == "" || !c.setOrigin(header, origin) {
+		return
+	}
+	if len(c.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+}
+
+// setOrigin sets the allowed origin and credentials headers, returning
+// false when origin is not allowed. The origin is reflected instead of
+// using a wildcard when credentials are allowed since browsers reject that.
+func (c CORS) setOrigin(header http.Header, origin string) bool {
+	wildcard := len(c.AllowedOrigins) == 0
+	allowed := c.ReflectOrigin || wildcard
+
+	for _, allowedOrigin := range c.AllowedOrigins {
+		if allowedOrigin == "*" {
+			wildcard = true
+			allowed = true
+		}
+		if strings.EqualFold(allowedOrigin, origin) {
+			allowed = true
+		}
+	}
+
+	if !allowed {
+		return false
+	}
+
+	if wildcard && !c.ReflectOrigin && !c.AllowCredentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	return true
+}
@@ -0,0 +1,229 @@
+package app_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/inquizarus/rwapper/v2/pkg/servemuxwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCORSTestRouter() http.Handler {
+	config := app.Config{
+		CORS: &app.CORS{
+			AllowedOrigins: []string{"http://localhost:3000"},
+			ExposedHeaders: []string{"X-Request-Id"},
+			MaxAge:         600,
+		},
+		Routes: []app.Route{
+			{Name: "list", Path: "/items", Method: http.MethodGet, Response: app.Response{StatusCode: http.StatusOK, Body: "list"}},
+			{Name: "create", Path: "/items", Method: http.MethodPost, Response: app.Response{StatusCode: http.StatusCreated, Body: "created"}},
+			{
+				Name:     "private",
+				Path:     "/private",
+				Method:   http.MethodGet,
+				Response: app.Response{StatusCode: http.StatusOK, Body: "private"},
+				CORS:     &app.CORS{ReflectOrigin: true, AllowCredentials: true, AllowedHeaders: []string{"Authorization"}},
+			},
+			{
+				Name:     "closed",
+				Path:     "/closed",
+				Method:   http.MethodGet,
+				Response: app.Response{StatusCode: http.StatusOK, Body: "closed"},
+				CORS:     &app.CORS{Disabled: true},
+			},
+		},
+	}
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(config, router, testLogger)
+
+	return router
+}
+
+func TestThatPreflightRequestsAreAnswered(t *testing.T) {
+	router := newCORSTestRouter()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodOptions, "/items", nil)
+	r.Header.Set("Origin", "http://localhost:3000")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "Content-Type")
+
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", w.Header().Get("Access-Control-Max-Age"))
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestThatPreflightRequestsFromUnknownOriginsAreRejected(t *testing.T) {
+	router := newCORSTestRouter()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodOptions, "/items", nil)
+	r.Header.Set("Origin", "http://evil.example")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestThatRouteCORSOverridesConfig(t *testing.T) {
+	router := newCORSTestRouter()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodOptions, "/private", nil)
+	r.Header.Set("Origin", "http://anything.example")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://anything.example", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "Authorization", w.Header().Get("Access-Control-Allow-Headers"))
+
+	w = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodOptions, "/closed", nil)
+	r.Header.Set("Origin", "http://localhost:3000")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Empty(t, w.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestThatActualRequestsGetCORSHeaders(t *testing.T) {
+	router := newCORSTestRouter()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/items", nil)
+	r.Header.Set("Origin", "http://localhost:3000")
+
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "created", w.Body.String())
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Request-Id", w.Header().Get("Access-Control-Expose-Headers"))
+}
+
+func TestThatRoutesSharingAPathAreDispatchedOnMethod(t *testing.T) {
+	router := newCORSTestRouter()
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+	assert.Equal(t, "list", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/items", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, POST", w.Header().Get("Allow"))
+}
+
+// methodRouter routes on method and path like most routers do, refusing a
+// method and path that is already registered.
+type methodRouter struct {
+	handlers map[string]http.Handler
+}
+
+func newMethodRouter() *methodRouter {
+	return &methodRouter{handlers: map[string]http.Handler{}}
+}
+
+func (m *methodRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := m.handlers[r.Method+" "+r.URL.Path]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
+func (m *methodRouter) Handle(method, path string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) {
+	if _, ok := m.handlers[method+" "+path]; ok {
+		panic(method + " " + path + " is already registered")
+	}
+	m.handlers[method+" "+path] = handler
+}
+
+func (m *methodRouter) Handler(method, path string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) {
+	m.Handle(method, path, handler, middlewares...)
+}
+
+func (m *methodRouter) HandlerFunc(method, path string, handler http.HandlerFunc, middlewares ...func(http.Handler) http.Handler) {
+	m.Handle(method, path, handler, middlewares...)
+}
+
+func (m *methodRouter) ParameterByName(name string, _ *http.Request) string { return name }
+func (m *methodRouter) Parameterize(name string) string                     { return name }
+
+func TestThatEveryMethodIsRegisteredWithRoutersSeparatingMethods(t *testing.T) {
+	router := newMethodRouter()
+	app.RegisterRoutes(app.Config{
+		CORS:  &app.CORS{AllowedOrigins: []string{"http://localhost:3000"}},
+		Admin: &app.AdminConfig{Enabled: true},
+		Routes: []app.Route{
+			{Name: "list", Path: "/items", Method: http.MethodGet, Response: app.Response{StatusCode: http.StatusOK, Body: "list"}},
+			{Name: "create", Path: "/items", Method: http.MethodPost, Response: app.Response{StatusCode: http.StatusCreated, Body: "created"}},
+		},
+	}, router, testLogger)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/items", nil))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodOptions, "/items", nil)
+	r.Header.Set("Origin", "http://localhost:3000")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	router.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/_gomsvc/journal", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestThatPreflightRequestsAreAnsweredForRoutesWithMatchers(t *testing.T) {
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(app.Config{
+		CORS: &app.CORS{AllowedOrigins: []string{"http://localhost:3000"}},
+		Routes: []app.Route{
+			{
+				Name:     "v1",
+				Path:     "/items",
+				Method:   http.MethodPost,
+				Match:    &app.RequestMatcher{Headers: map[string]app.Matcher{"X-Version": {Exact: "1"}}},
+				Response: app.Response{StatusCode: http.StatusCreated, Body: "v1"},
+			},
+			{
+				Name:     "v2",
+				Path:     "/items",
+				Method:   http.MethodPost,
+				Match:    &app.RequestMatcher{Headers: map[string]app.Matcher{"X-Version": {Exact: "2"}}},
+				Response: app.Response{StatusCode: http.StatusCreated, Body: "v2"},
+			},
+		},
+	}, router, testLogger)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodOptions, "/items", nil)
+	r.Header.Set("Origin", "http://localhost:3000")
+	r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	r.Header.Set("Access-Control-Request-Headers", "X-Version")
+	router.ServeHTTP(w, r)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "http://localhost:3000", w.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "X-Version", w.Header().Get("Access-Control-Allow-Headers"))
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// Fanout decides how the upstreams of a route are called. Upstreams are
+// called concurrently unless Sequential is set, Concurrency limits how many
+// calls are in flight at once and Deadline bounds all of them together.
+type Fanout struct {
+	Sequential  bool     `json:"sequential"`
+	Concurrency int      `json:"concurrency"`
+	Deadline    Duration `json:"deadline"`
+}
+
+// call calls every upstream, rendered with base, and returns their results
+// in the configured order. The calls are canceled when ctx is done, which
+// happens when the client of the incoming request goes away.
+func (f Fanout) call(ctx context.Context, clients upstreamClients, upstreams []Upstream, r *http.Request, base templateData) []upstreamResult {
+	results := make([]upstreamResult, len(upstreams))
+
+	if f.Deadline.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.Deadline.Duration)
+		defer cancel()
+	}
+
+	limit := f.Concurrency
+	if f.Sequential {
+		limit = 1
+	}
+	if limit <= 0 || limit > len(upstreams) {
+		limit = len(upstreams)
+	}
+
+	slots := make(chan struct{}, limit)
+	done := make([]chan struct{}, len(upstreams))
+	var wg sync.WaitGroup
+
+	for i := range upstreams {
+		done[i] = make(chan struct{})
+	}
+
+	for i, upstream := range upstreams {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int, upstream Upstream) {
+			defer func() {
+				close(done[i])
+				<-slots
+				wg.Done()
+			}()
+
+			// Upstreams using earlier results wait for them, earlier
+			// upstreams already have a slot so waiting can not deadlock
+			data := base
+			if upstream.chained() {
+				for _, previous := range done[:i] {
+					<-previous
+				}
+				data.Upstreams = newTemplateUpstreams(results[:i])
+			}
+
+			prepared, run, err := upstream.prepare(data)
+
+			switch {
+			case err != nil:
+				results[i] = upstreamResult{upstream: upstream, err: err}
+			case !run:
+				results[i] = upstreamResult{upstream: upstream, skipped: true}
+			default:
+				client, err := clients(prepared)
+				if err != nil {
+					results[i] = upstreamResult{upstream: prepared, err: err}
+					return
+				}
+				results[i] = prepared.call(ctx, client, r)
+			}
+		}(i, upstream)
+	}
+
+	wg.Wait()
+
+	return results
+}
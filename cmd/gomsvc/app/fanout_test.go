@@ -0,0 +1,162 @@
+package app_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowServer answers with its name after delay and keeps track of how many
+// requests to all slow servers sharing inFlight are handled at once.
+func slowServer(t *testing.T, name string, delay time.Duration, inFlight, maxInFlight *int32) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := atomic.AddInt32(inFlight, 1)
+		defer atomic.AddInt32(inFlight, -1)
+		for {
+			seen := atomic.LoadInt32(maxInFlight)
+			if current <= seen || atomic.CompareAndSwapInt32(maxInFlight, seen, current) {
+				break
+			}
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		w.Write([]byte(`{"name": "` + name + `"}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func fanoutRoute(fanout app.Fanout, upstreams ...app.Upstream) app.Route {
+	return app.Route{
+		Name:      "fanout",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: upstreams,
+		Fanout:    fanout,
+		Response: app.Response{
+			StatusCode:               http.StatusOK,
+			Headers:                  map[string]string{"content-type": "application/json"},
+			IncludeUpstreamResponses: true,
+			Passthrough:              &app.Passthrough{ErrorStatus: http.StatusGatewayTimeout},
+		},
+	}
+}
+
+func upstreamNames(t *testing.T, data []byte) []string {
+	var body struct {
+		Upstreams []struct {
+			Body struct {
+				Name string `json:"name"`
+			} `json:"body"`
+		} `json:"upstreams"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &body))
+	names := []string{}
+	for _, upstream := range body.Upstreams {
+		names = append(names, upstream.Body.Name)
+	}
+	return names
+}
+
+func TestThatHandlerCallsUpstreamsConcurrentlyInOrder(t *testing.T) {
+	var inFlight, maxInFlight int32
+	upstreams := []app.Upstream{}
+	for i, delay := range []time.Duration{150, 50, 100} {
+		server := slowServer(t, strconv.Itoa(i), delay*time.Millisecond, &inFlight, &maxInFlight)
+		upstreams = append(upstreams, app.Upstream{URL: server.URL, Method: http.MethodGet})
+	}
+
+	w := httptest.NewRecorder()
+	started := time.Now()
+	app.MakeHandlerFunc(fanoutRoute(app.Fanout{}, upstreams...), app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Less(t, time.Since(started), 290*time.Millisecond)
+	assert.Equal(t, int32(3), maxInFlight)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"0", "1", "2"}, upstreamNames(t, w.Body.Bytes()))
+}
+
+func TestThatHandlerLimitsUpstreamConcurrency(t *testing.T) {
+	tests := []struct {
+		name   string
+		fanout app.Fanout
+		max    int32
+	}{
+		{name: "sequential", fanout: app.Fanout{Sequential: true}, max: 1},
+		{name: "limited", fanout: app.Fanout{Concurrency: 2}, max: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inFlight, maxInFlight int32
+			upstreams := []app.Upstream{}
+			for i := 0; i < 4; i++ {
+				server := slowServer(t, strconv.Itoa(i), 20*time.Millisecond, &inFlight, &maxInFlight)
+				upstreams = append(upstreams, app.Upstream{URL: server.URL, Method: http.MethodGet})
+			}
+
+			w := httptest.NewRecorder()
+			app.MakeHandlerFunc(fanoutRoute(tt.fanout, upstreams...), app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, tt.max, maxInFlight)
+			assert.Equal(t, []string{"0", "1", "2", "3"}, upstreamNames(t, w.Body.Bytes()))
+		})
+	}
+}
+
+func TestThatHandlerTimesOutUpstreams(t *testing.T) {
+	var inFlight, maxInFlight int32
+	fast := slowServer(t, "fast", 10*time.Millisecond, &inFlight, &maxInFlight)
+	slow := slowServer(t, "slow", time.Second, &inFlight, &maxInFlight)
+
+	tests := []struct {
+		name      string
+		fanout    app.Fanout
+		upstreams []app.Upstream
+		status    int
+		names     []string
+	}{
+		{
+			name:      "upstream timeout",
+			upstreams: []app.Upstream{{URL: fast.URL, Method: http.MethodGet}, {URL: slow.URL, Method: http.MethodGet, Timeout: app.Duration{Duration: 50 * time.Millisecond}}},
+			status:    http.StatusGatewayTimeout,
+			names:     []string{"fast", ""},
+		},
+		{
+			name:      "route deadline",
+			fanout:    app.Fanout{Deadline: app.Duration{Duration: 50 * time.Millisecond}},
+			upstreams: []app.Upstream{{URL: fast.URL, Method: http.MethodGet}, {URL: slow.URL, Method: http.MethodGet}},
+			status:    http.StatusGatewayTimeout,
+			names:     []string{"fast", ""},
+		},
+		{
+			name:      "upstream within timeout",
+			upstreams: []app.Upstream{{URL: fast.URL, Method: http.MethodGet, Timeout: app.Duration{Duration: time.Second}}},
+			status:    http.StatusOK,
+			names:     []string{"fast"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			started := time.Now()
+			app.MakeHandlerFunc(fanoutRoute(tt.fanout, tt.upstreams...), app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Less(t, time.Since(started), 500*time.Millisecond)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.names, upstreamNames(t, w.Body.Bytes()))
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+)
+
+// lookupField finds the value at a dot separated path such as user.tags.0
+// where numeric segments index into arrays.
+func lookupField(document interface{}, path string) (interface{}, bool) {
+	current := document
+	for _, segment := range strings.Split(path, ".") {
+		switch v := current.(type) {
+		case map[string]interface{}:
+			next, ok := v[segment]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			current = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setField sets value at a dot separated path such as meta.total in
+// document, creating any objects along the way.
+func setField(document map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	current := document
+	for _, segment := range segments[:len(segments)-1] {
+		next, ok := current[segment].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			current[segment] = next
+		}
+		current = next
+	}
+	current[segments[len(segments)-1]] = value
+}
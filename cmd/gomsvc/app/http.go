@@ -1,50 +1,385 @@
 package app
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/inquizarus/gomsvc/pkg/logging"
 )
 
+// MakePathHandlerFunc returns a handler for routes that share the same
+// path, requests are passed on to the route with a matching method and CORS
+// is handled before that.
+func MakePathHandlerFunc(routes []Route, config Config, log logging.Logger) http.HandlerFunc {
+	handlers := make([]http.HandlerFunc, len(routes))
+	for i, route := range routes {
+		handlers[i] = MakeHandlerFunc(route, config, log)
+	}
+
+	// Matchers are compiled ahead so an invalid one is reported when the
+	// routes are added, requests that reach such a route in order are
+	// answered with the error instead of being matched
+	routes = append([]Route{}, routes...)
+	invalid := make([]bool, len(routes))
+	for i, route := range routes {
+		if route.Match == nil {
+			continue
+		}
+		match, err := route.Match.compile()
+		if err != nil {
+			log.Error("could not set up matcher for route " + route.Name + ", " + err.Error())
+			invalid[i] = true
+			handlers[i] = func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			continue
+		}
+		routes[i].Match = &match
+	}
+
+	methods := routeMethods(routes)
+
+	// Routes with matchers are tried before routes without them, which
+	// serve as fallbacks for requests no matcher matched. Preflights carry
+	// nothing to match on, so they are passed without a request and go to
+	// the first route handling the method
+	find := func(method string, r *http.Request) int {
+		fallback := -1
+		for i, route := range routes {
+			if !route.handles(method) {
+				continue
+			}
+			if r == nil {
+				return i
+			}
+			if route.Match == nil {
+				if fallback < 0 {
+					fallback = i
+				}
+				continue
+			}
+			if invalid[i] || route.Match.Match(r) {
+				return i
+			}
+		}
+		return fallback
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		r = withSession(r, config.Session)
+
+		if isPreflight(r) {
+			if i := find(r.Header.Get("Access-Control-Request-Method"), nil); i >= 0 {
+				if cors := corsFor(routes[i], config); cors != nil {
+					cors.Preflight(w, r, methods)
+					log.Info("answered preflight request to route " + routes[i].Name)
+					return
+				}
+			}
+		}
+
+		i := find(r.Method, r)
+
+		if i < 0 {
+			// Requests that no matcher matched were still sent with a
+			// method the path handles
+			status := http.StatusNotFound
+			if !handlesMethod(routes, r.Method) {
+				status = http.StatusMethodNotAllowed
+				w.Header().Set("Allow", strings.Join(methods, ", "))
+			}
+			w.WriteHeader(status)
+			log.Info("could not find a route for " + r.Method + " " + r.URL.Path)
+			return
+		}
+
+		if cors := corsFor(routes[i], config); cors != nil {
+			cors.SetHeaders(w.Header(), r.Header.Get("Origin"))
+		}
+
+		handlers[i](w, r)
+	}
+}
+
 func MakeHandlerFunc(route Route, config Config, log logging.Logger) http.HandlerFunc {
+	var proxy http.Handler
+
+	upstreams, async := splitUpstreams(route.Upstreams)
+
+	clients := func(upstream Upstream) (*http.Client, error) {
+		return upstreamClient(config, upstream)
+	}
+
+	// Upstreams are checked and their clients set up ahead so
+	// configuration errors are reported when the route is added
+	var setupErr error
+
+	for _, upstream := range route.Upstreams {
+		if setupErr = upstream.validate(); setupErr == nil {
+			_, setupErr = clients(upstream)
+		}
+		if setupErr != nil {
+			log.Error("could not set up upstream calls for route " + route.Name + ", " + setupErr.Error())
+			break
+		}
+	}
+
+	// Incoming bodies are only read, and limited in size, for routes that
+	// use them
+	readsBody := route.readsRequestBody()
+
+	if route.Kind == RouteKindProxy && route.Proxy != nil {
+		var err error
+		if proxy, err = route.Proxy.Handler(log); err != nil {
+			log.Error("could not set up proxy for route " + route.Name + ", " + err.Error())
+		}
+	}
+
+	if route.Kind == RouteKindWebSocket && route.WebSocket != nil {
+		webSocket, err := route.WebSocket.compile()
+		if err != nil {
+			log.Error("could not set up websocket for route " + route.Name + ", " + err.Error())
+			setupErr = err
+		}
+		route.WebSocket = &webSocket
+	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		log.Info("starting to handle request to route " + route.Name)
 
+		entry := journal.add(route.Name, r)
+
+		r = withSession(r, config.Session)
+
 		// Initial checking to determine if the incoming request is a valid one according
 		// to the route configuration. Usually this is already handled by a router.
 
-		if r.Method != route.Method {
+		if !route.handles(r.Method) {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 			log.Info("could not finish handling for request to " + route.Name + " wrong HTTP method " + r.Method)
 			return
 		}
 
-		// Lets handle all potential upstreams
+		if route.Kind == RouteKindSSE && route.SSE != nil {
+			route.SSE.Serve(w, r, log)
+			log.Info("finished handling request to route " + route.Name)
+			return
+		}
+
+		if route.Kind == RouteKindProxy && route.Proxy != nil {
+			if proxy == nil {
+				w.WriteHeader(http.StatusBadGateway)
+			} else {
+				proxy.ServeHTTP(w, r)
+			}
+			log.Info("finished handling request to route " + route.Name)
+			return
+		}
+
+		if route.Kind == RouteKindWebSocket && route.WebSocket != nil {
+			if setupErr != nil {
+				http.Error(w, setupErr.Error(), http.StatusInternalServerError)
+				return
+			}
+			route.WebSocket.Serve(w, r, route.Name, log)
+			log.Info("finished handling request to route " + route.Name)
+			return
+		}
+
+		// Pick which body to serve based on the Accept header before doing
+		// any upstream calls, there is no point in making them if nothing
+		// acceptable can be returned
+
+		response, acceptable := route.Response.Negotiate(r)
 
-		upstreamResponses := []*http.Response{}
-		for _, upstream := range route.Upstreams {
-			upstreamResponse, err := upstream.Call(http.DefaultClient, r)
+		if len(route.Response.Bodies) > 0 {
+			w.Header().Add("Vary", "Accept")
+		}
+
+		if !acceptable {
+			w.WriteHeader(http.StatusNotAcceptable)
+			log.Info("could not finish handling for request to " + route.Name + " no acceptable body for " + r.Header.Get("Accept"))
+			return
+		}
+
+		if response.Pagination != nil {
+			body, headers, err := response.Pagination.Paginate(r)
 			if err != nil {
-				log.Info("error when performing upstream request " + err.Error() + ", skipping to next upstream call")
-				continue
+				status := http.StatusInternalServerError
+				if errors.Is(err, ErrInvalidPageRequest) {
+					status = http.StatusBadRequest
+				}
+				http.Error(w, err.Error(), status)
+				log.Info("could not paginate request to route " + route.Name + ", " + err.Error())
+				return
+			}
+			for k, v := range headers {
+				w.Header()[k] = v
+			}
+			response.Body = body
+		}
+
+		// Preconditions that do not depend on the rendered response are
+		// evaluated before any upstream calls so a failed conditional
+		// request does not cause any side effects
+
+		if !response.computesETag() {
+			if status := response.precondition(r, nil); status != 0 {
+				response.setValidators(w.Header(), nil)
+				w.WriteHeader(status)
+				log.Info("finished handling conditional request to route " + route.Name + " with status " + strconv.Itoa(status))
+				return
 			}
-			upstreamResponses = append(upstreamResponses, upstreamResponse)
+		}
 
+		// Lets handle all potential upstreams
+
+		if setupErr != nil && len(route.Upstreams) > 0 {
+			http.Error(w, setupErr.Error(), http.StatusInternalServerError)
+			return
 		}
 
-		for k, v := range route.Response.Headers {
-			w.Header().Set(k, v)
+		// The incoming request is only described once since its body can
+		// not be read by several upstreams at the same time
+		templates := newTemplateData(r, readsBody)
+
+		var tooLarge *http.MaxBytesError
+		if errors.As(templates.Request.bodyErr, &tooLarge) {
+			http.Error(w, "request body is larger than "+strconv.FormatInt(tooLarge.Limit, 10)+" bytes", http.StatusRequestEntityTooLarge)
+			log.Info("could not finish handling for request to " + route.Name + ", request body is too large")
+			return
+		}
+
+		results := route.Fanout.call(r.Context(), clients, upstreams, r, templates)
+		for _, result := range results {
+			if result.shortCircuited {
+				log.Info("circuit breaker for " + result.upstream.url() + " is open, skipped upstream call")
+			}
+			if result.upstream.Retry != nil {
+				for i, attempt := range result.attempts {
+					log.Info(result.redact("upstream call to " + result.url() + " attempt " + strconv.Itoa(i+1) + " " + attempt.describe()))
+				}
+			}
+			if result.err != nil {
+				log.Info("error when performing upstream request " + result.redact(result.err.Error()) + ", skipping upstream response")
+			}
+		}
+
+		// Replaying a cassette is meant to be hermetic, so a request that
+		// was never recorded fails the whole response instead of being
+		// skipped like other upstream errors
+		for _, result := range results {
+			if errors.Is(result.err, ErrCassetteMiss) {
+				log.Error(result.redact(result.err.Error()))
+				http.Error(w, result.redact(result.err.Error()), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if response.Passthrough != nil {
+			response.StatusCode = response.Passthrough.statusCode(results, response.StatusCode)
 		}
 
-		w.WriteHeader(route.Response.StatusCode)
+		templates.Upstreams = newTemplateUpstreams(results)
 
-		data, err := route.Response.Content(r, upstreamResponses)
+		if response.Session != nil {
+			var err error
+			if r, err = response.Session.apply(w, r, templates, config.Session); err != nil {
+				log.Error(err)
+			}
+			templates.Session = sessions.Values(sessionID(r))
+		}
+
+		// Asynchronous upstreams are called back once the response has been
+		// written, the same way a service acknowledging a request would
+		if len(async) > 0 {
+			defer func(data templateData) {
+				for _, upstream := range async {
+					entry.addCallback(callbacks.schedule(clients, upstream, data, r, log))
+				}
+			}(templates)
+		}
+
+		data, err := response.render(r, templates, results)
 
 		if nil != err {
 			log.Error(err)
 		}
 
+		for k, v := range response.Headers {
+			w.Header().Set(k, v)
+		}
+
+		if response.Passthrough != nil {
+			for k, v := range response.Passthrough.header(results) {
+				w.Header()[k] = v
+			}
+		}
+
+		for _, cookie := range response.Cookies {
+			httpCookie, err := cookie.HTTPCookie(templates)
+			if err != nil {
+				log.Error(err)
+				continue
+			}
+			http.SetCookie(w, httpCookie)
+		}
+
+		if response.Compression != nil {
+			// Content type has to be detected before encoding or it would be
+			// sniffed from the compressed bytes instead, precompressed bodies
+			// are already encoded so their type is taken from the file name
+			if w.Header().Get("Content-Type") == "" {
+				if response.Compression.Precompressed != "" {
+					w.Header().Set("Content-Type", response.precompressedContentType())
+				} else {
+					w.Header().Set("Content-Type", http.DetectContentType(data))
+				}
+			}
+			var encoding string
+			data, encoding, err = response.Compression.Apply(r, w.Header(), data)
+			if nil != err {
+				log.Error(err)
+			}
+			if encoding != "" {
+				w.Header().Set("Content-Encoding", encoding)
+			}
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
+
+		response.setValidators(w.Header(), data)
+
+		if response.computesETag() {
+			if status := response.precondition(r, data); status != 0 {
+				w.WriteHeader(status)
+				log.Info("finished handling conditional request to route " + route.Name + " with status " + strconv.Itoa(status))
+				return
+			}
+		}
+
+		if response.servesRanges() {
+			response.serveRanges(w, r, data)
+			log.Info("finished handling request to route " + route.Name)
+			return
+		}
+
+		if response.DisableRanges && response.filePath() != "" {
+			w.Header().Set("Accept-Ranges", "none")
+		}
+
+		if response.Throttle != nil {
+			if err := response.Throttle.Serve(w, r, response.StatusCode, data); err != nil {
+				log.Info("could not finish writing throttled response to route " + route.Name + ", " + err.Error())
+			}
+			log.Info("finished handling request to route " + route.Name)
+			return
+		}
+
+		w.WriteHeader(response.StatusCode)
+
 		w.Write(data)
 
 		log.Info("finished handling request to route " + route.Name)
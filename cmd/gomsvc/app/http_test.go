@@ -0,0 +1,178 @@
+package app_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+	"github.com/inquizarus/gomsvc/pkg/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLogger = logging.NewPlainLogger(io.Discard, "")
+
+func TestThatHandlerNegotiatesBodyFromAcceptHeader(t *testing.T) {
+	route := app.Route{
+		Name:   "negotiated",
+		Path:   "/",
+		Method: http.MethodGet,
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Bodies: map[string]interface{}{
+				"application/json": map[string]interface{}{"id": 1},
+				"application/xml":  map[string]interface{}{"id": 1},
+				"text/csv":         "id\n1\n",
+			},
+		},
+	}
+
+	handler := app.MakeHandlerFunc(route, app.Config{}, testLogger)
+
+	tests := []struct {
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{accept: "", status: http.StatusOK, contentType: "application/json", body: "{\n \"id\": 1\n}"},
+		{accept: "text/csv, application/json;q=0.5", status: http.StatusOK, contentType: "text/csv", body: "id\n1\n"},
+		{accept: "application/*;q=0.9, application/json;q=0.1", status: http.StatusOK, contentType: "application/xml", body: "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<response>\n <id>1</id>\n</response>"},
+		{accept: "image/png", status: http.StatusNotAcceptable, contentType: "", body: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept", tt.accept)
+
+			handler(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			assert.Equal(t, "Accept", w.Header().Get("Vary"))
+			assert.Equal(t, tt.body, w.Body.String())
+		})
+	}
+}
+
+func TestThatHandlerCompressesResponses(t *testing.T) {
+	body := strings.Repeat("hello, world! ", 20)
+	precompressed, _ := httptools.Encode(httptools.EncodingGzip, []byte(body))
+	path := filepath.Join(t.TempDir(), "body.txt.gz")
+	os.WriteFile(path, precompressed, 0o644)
+
+	tests := []struct {
+		name           string
+		compression    app.Compression
+		body           string
+		acceptEncoding string
+		encoding       string
+	}{
+		{name: "negotiated", compression: app.Compression{}, body: body, acceptEncoding: "gzip, br;q=0.5", encoding: "gzip"},
+		{name: "not accepted", compression: app.Compression{}, body: body, acceptEncoding: "", encoding: ""},
+		{name: "below min size", compression: app.Compression{MinSize: 1024}, body: body, acceptEncoding: "gzip", encoding: ""},
+		{name: "content type not allowed", compression: app.Compression{ContentTypes: []string{"application/*"}}, body: body, acceptEncoding: "gzip", encoding: ""},
+		{name: "forced", compression: app.Compression{Force: "deflate"}, body: body, acceptEncoding: "", encoding: "deflate"},
+		{name: "precompressed", compression: app.Compression{Precompressed: "gzip"}, body: "file:" + path, acceptEncoding: "", encoding: "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compression := tt.compression
+			route := app.Route{
+				Name:   tt.name,
+				Path:   "/",
+				Method: http.MethodGet,
+				Response: app.Response{
+					StatusCode:  http.StatusOK,
+					Headers:     map[string]string{"content-type": "text/plain"},
+					Body:        tt.body,
+					Compression: &compression,
+				},
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+
+			app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, r)
+
+			assert.Equal(t, tt.encoding, w.Header().Get("Content-Encoding"))
+
+			data := w.Body.Bytes()
+			switch tt.encoding {
+			case "gzip":
+				reader, err := gzip.NewReader(bytes.NewReader(data))
+				assert.NoError(t, err)
+				data, _ = io.ReadAll(reader)
+			case "deflate":
+				reader, err := zlib.NewReader(bytes.NewReader(data))
+				assert.NoError(t, err)
+				data, _ = io.ReadAll(reader)
+			}
+			assert.Equal(t, body, string(data))
+		})
+	}
+}
+
+func TestThatHandlerCanSendMislabeledContentEncoding(t *testing.T) {
+	route := app.Route{
+		Name:   "mislabeled",
+		Path:   "/",
+		Method: http.MethodGet,
+		Response: app.Response{
+			StatusCode:  http.StatusOK,
+			Body:        "not compressed at all",
+			Compression: &app.Compression{ContentEncoding: "gzip"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "not compressed at all", w.Body.String())
+}
+
+func TestThatPrecompressedBodiesGetContentTypeFromFileName(t *testing.T) {
+	precompressed, _ := httptools.Encode(httptools.EncodingGzip, []byte(`{"id": 1}`))
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "body.json.gz"), precompressed, 0o644)
+	os.WriteFile(filepath.Join(dir, "body.gz"), precompressed, 0o644)
+
+	tests := map[string]string{
+		"body.json.gz": "application/json",
+		"body.gz":      "application/octet-stream",
+	}
+
+	for name, contentType := range tests {
+		route := app.Route{
+			Name:   name,
+			Path:   "/",
+			Method: http.MethodGet,
+			Response: app.Response{
+				StatusCode:  http.StatusOK,
+				Body:        "file:" + filepath.Join(dir, name),
+				Compression: &app.Compression{Precompressed: httptools.EncodingGzip},
+			},
+		}
+
+		w := httptest.NewRecorder()
+		app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		assert.Equal(t, contentType, w.Header().Get("Content-Type"), name)
+		assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"), name)
+		assert.Equal(t, precompressed, w.Body.Bytes(), name)
+	}
+}
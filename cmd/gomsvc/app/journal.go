@@ -0,0 +1,106 @@
+package app
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultJournalSize = 100
+
+// journal keeps the most recent requests handled by routes together with
+// the callbacks they scheduled.
+var journal = &requestJournal{size: defaultJournalSize}
+
+// JournalEntry describes a request handled by a route.
+type JournalEntry struct {
+	ID        int64            `json:"id"`
+	Time      time.Time        `json:"time"`
+	Route     string           `json:"route"`
+	Method    string           `json:"method"`
+	Path      string           `json:"path"`
+	Query     string           `json:"query,omitempty"`
+	Callbacks []CallbackStatus `json:"callbacks"`
+}
+
+type requestJournal struct {
+	mu      sync.Mutex
+	size    int
+	next    int64
+	entries []*journalEntry
+}
+
+type journalEntry struct {
+	mu        sync.Mutex
+	entry     JournalEntry
+	callbacks []*callback
+}
+
+// add records a request to route, the oldest entry is dropped when the
+// journal is full.
+func (j *requestJournal) add(route string, r *http.Request) *journalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.next++
+
+	entry := &journalEntry{entry: JournalEntry{
+		ID:     j.next,
+		Time:   time.Now(),
+		Route:  route,
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.RawQuery,
+	}}
+
+	j.entries = append(j.entries, entry)
+
+	if len(j.entries) > j.size {
+		j.entries = j.entries[len(j.entries)-j.size:]
+	}
+
+	return entry
+}
+
+// Entries returns every entry in the journal, oldest first.
+func (j *requestJournal) Entries() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries := make([]JournalEntry, len(j.entries))
+
+	for i, entry := range j.entries {
+		entries[i] = entry.status()
+	}
+
+	return entries
+}
+
+// Clear removes every entry from the journal.
+func (j *requestJournal) Clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.entries = nil
+}
+
+func (e *journalEntry) addCallback(cb *callback) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.callbacks = append(e.callbacks, cb)
+}
+
+func (e *journalEntry) status() JournalEntry {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	entry := e.entry
+	entry.Callbacks = make([]CallbackStatus, len(e.callbacks))
+
+	for i, cb := range e.callbacks {
+		entry.Callbacks[i] = cb.status()
+	}
+
+	return entry
+}
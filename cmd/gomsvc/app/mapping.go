@@ -0,0 +1,146 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+	"github.com/inquizarus/gomsvc/internal/pkg/jsonpath"
+)
+
+// Mapping copies the value found at Source, a JSONPath expression such as
+// $.upstreams[0].body.name, into the response body at Target, a dot
+// separated path such as user.name. Default is used when nothing is found.
+type Mapping struct {
+	Target  string      `json:"target"`
+	Source  string      `json:"source"`
+	Default interface{} `json:"default"`
+}
+
+// mappedBody returns a copy of the body with every mapping applied, the
+// body has to be an object or empty for the mapped fields to be set.
+func (r Response) mappedBody(request *http.Request, results []upstreamResult) (interface{}, error) {
+	var body map[string]interface{}
+
+	if r.Body == nil {
+		body = map[string]interface{}{}
+	} else {
+		copied, err := r.copyBody()
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if body, ok = copied.(map[string]interface{}); !ok {
+			return nil, errors.New("mappings can only be applied to an object body")
+		}
+	}
+
+	document, err := mappingDocument(request, results)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, mapping := range r.Mappings {
+		target := strings.TrimPrefix(mapping.Target, "$.")
+		if target == "" {
+			return nil, errors.New("mapping from " + mapping.Source + " has no target")
+		}
+
+		value, found, err := jsonpath.Lookup(document, mapping.Source)
+		if err != nil {
+			return nil, err
+		}
+
+		if !found || value == nil {
+			if mapping.Default == nil {
+				continue
+			}
+			value = mapping.Default
+		}
+
+		setField(body, target, value)
+	}
+
+	return body, nil
+}
+
+// mappingDocument returns the document mapping sources are looked up in,
+// which holds the incoming request and the results of all upstreams in the
+// configured order. Upstream bodies are decoded when they are JSON and kept
+// as text otherwise, upstreams that could not be reached only have an error.
+// Upstream secrets are redacted the same way as in the upstream section.
+func mappingDocument(request *http.Request, results []upstreamResult) (interface{}, error) {
+	upstreams := []interface{}{}
+
+	for _, result := range results {
+		if result.skipped {
+			upstreams = append(upstreams, map[string]interface{}{"skipped": true})
+			continue
+		}
+		if result.err != nil {
+			upstreams = append(upstreams, map[string]interface{}{
+				"url":   result.redact(result.url()),
+				"error": result.redact(result.err.Error()),
+			})
+			continue
+		}
+		data, err := upstreamBody(result.response)
+		if err != nil {
+			return nil, err
+		}
+		data = []byte(result.redact(string(data)))
+		var body interface{} = string(data)
+		if httptools.IsJSON(result.response.Header) {
+			var container interface{}
+			if err := json.Unmarshal(data, &container); err == nil {
+				body = container
+			}
+		}
+		upstreams = append(upstreams, map[string]interface{}{
+			"url":         result.redact(result.url()),
+			"status_code": result.response.StatusCode,
+			"headers":     firstValues(redactHeader(result.response.Header, result.secrets)),
+			"body":        body,
+		})
+	}
+
+	document := map[string]interface{}{
+		"request": map[string]interface{}{
+			"method":    request.Method,
+			"path":      request.URL.Path,
+			"query":     firstValues(request.URL.Query()),
+			"headers":   firstValues(request.Header),
+			"cookies":   requestCookies(request),
+			"client_ip": httptools.ClientIP(request),
+		},
+		"upstreams": upstreams,
+	}
+
+	// A round trip through JSON leaves only the plain types that JSONPath
+	// expressions know how to walk through
+	data, err := json.Marshal(document)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var normalized interface{}
+	err = json.Unmarshal(data, &normalized)
+
+	return normalized, err
+}
+
+// firstValues returns the first value of every key, which is what mappings
+// almost always want from headers and query parameters.
+func firstValues(values map[string][]string) map[string]string {
+	first := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 0 {
+			first[k] = v[0]
+		}
+	}
+	return first
+}
@@ -0,0 +1,85 @@
+package app_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func upstreamJSONResponse(body string) *http.Response {
+	u, _ := url.Parse("http://upstream.example.com/users/1")
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(body)),
+		Request:    &http.Request{URL: u},
+	}
+	response.Header.Set("content-type", "application/json")
+	return response
+}
+
+func TestResponseMappings(t *testing.T) {
+	response := app.Response{
+		Headers: map[string]string{"content-type": "application/json"},
+		Body:    `{"user": {"id": 1}}`,
+		Mappings: []app.Mapping{
+			{Target: "user.name", Source: "$.upstreams[0].body.name"},
+			{Target: "user.tags", Source: "$.upstreams[0].body.roles[*].name"},
+			{Target: "user.email", Source: "$.upstreams[0].body.email", Default: "unknown"},
+			{Target: "user.phone", Source: "$.upstreams[0].body.phone"},
+			{Target: "status", Source: "$.upstreams[0].status_code"},
+			{Target: "agent", Source: "$.request.headers['User-Agent']"},
+		},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.Header.Set("User-Agent", "test")
+	upstream := upstreamJSONResponse(`{"name": "john", "roles": [{"name": "admin"}, {"name": "dev"}]}`)
+
+	data, err := response.Content(req, []*http.Response{upstream})
+	assert.NoError(t, err)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &body))
+	assert.Equal(t, map[string]interface{}{
+		"user": map[string]interface{}{
+			"id":    float64(1),
+			"name":  "john",
+			"tags":  []interface{}{"admin", "dev"},
+			"email": "unknown",
+		},
+		"status": float64(200),
+		"agent":  "test",
+	}, body)
+}
+
+func TestResponseMappingsKeepUpstreamBodyReadable(t *testing.T) {
+	response := app.Response{
+		Headers:                  map[string]string{"content-type": "application/json"},
+		IncludeUpstreamResponses: true,
+		Mappings:                 []app.Mapping{{Target: "name", Source: "$.upstreams[0].body.name"}},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	data, err := response.Content(req, []*http.Response{upstreamJSONResponse(`{"name": "john"}`)})
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"name": "john"`)
+	assert.Contains(t, string(data), `"url": "http://upstream.example.com/users/1"`)
+}
+
+func TestResponseMappingsRequireObjectBody(t *testing.T) {
+	response := app.Response{
+		Headers:  map[string]string{"content-type": "application/json"},
+		Body:     `[1, 2]`,
+		Mappings: []app.Mapping{{Target: "name", Source: "$.request.method"}},
+	}
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+	_, err := response.Content(req, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+)
+
+// Matcher decides if a value matches, all of the configured criteria has
+// to match and a Matcher without any criteria matches everything.
+type Matcher struct {
+	Exact string                 `json:"exact"`
+	Regex string                 `json:"regex"`
+	JSON  map[string]interface{} `json:"json"`
+	// pattern is Regex compiled ahead by compile
+	pattern *regexp.Regexp
+}
+
+// compile returns the matcher with its regular expression compiled so it
+// is not compiled for every value and an invalid one is reported up front.
+func (m Matcher) compile() (Matcher, error) {
+	if m.Regex == "" {
+		return m, nil
+	}
+	pattern, err := regexp.Compile(m.Regex)
+	if err != nil {
+		return m, fmt.Errorf("invalid regex %q, %w", m.Regex, err)
+	}
+	m.pattern = pattern
+	return m, nil
+}
+
+func (m Matcher) Match(value string) bool {
+	if m.Exact != "" && m.Exact != value {
+		return false
+	}
+
+	if m.Regex != "" {
+		pattern := m.pattern
+		if pattern == nil {
+			var err error
+			if pattern, err = regexp.Compile(m.Regex); err != nil {
+				return false
+			}
+		}
+		if !pattern.MatchString(value) {
+			return false
+		}
+	}
+
+	if len(m.JSON) > 0 {
+		var document interface{}
+		if err := json.Unmarshal([]byte(value), &document); err != nil {
+			return false
+		}
+		for path, expected := range m.JSON {
+			actual, ok := lookupField(document, path)
+			if !ok || !matchesValue(expected, actual) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// RequestMatcher decides if a request should be handled by a route, every
+// configured matcher has to match the value with the given name. Missing
+// values are matched as empty strings.
+type RequestMatcher struct {
+	Headers map[string]Matcher `json:"headers"`
+	Query   map[string]Matcher `json:"query"`
+	Cookies map[string]Matcher `json:"cookies"`
+	Session map[string]Matcher `json:"session"`
+}
+
+// compile returns the matcher with the regular expressions of all its
+// matchers compiled, an invalid one is returned as an error.
+func (m RequestMatcher) compile() (RequestMatcher, error) {
+	compiled := RequestMatcher{}
+	for _, set := range []struct {
+		kind     string
+		matchers map[string]Matcher
+		compiled *map[string]Matcher
+	}{
+		{kind: "header", matchers: m.Headers, compiled: &compiled.Headers},
+		{kind: "query parameter", matchers: m.Query, compiled: &compiled.Query},
+		{kind: "cookie", matchers: m.Cookies, compiled: &compiled.Cookies},
+		{kind: "session value", matchers: m.Session, compiled: &compiled.Session},
+	} {
+		if set.matchers == nil {
+			continue
+		}
+		*set.compiled = make(map[string]Matcher, len(set.matchers))
+		for name, matcher := range set.matchers {
+			matcher, err := matcher.compile()
+			if err != nil {
+				return m, fmt.Errorf("%s %s has an %w", set.kind, name, err)
+			}
+			(*set.compiled)[name] = matcher
+		}
+	}
+	return compiled, nil
+}
+
+func (m RequestMatcher) Match(r *http.Request) bool {
+	for name, matcher := range m.Headers {
+		if !matcher.Match(r.Header.Get(name)) {
+			return false
+		}
+	}
+
+	query := r.URL.Query()
+	for name, matcher := range m.Query {
+		if !matcher.Match(query.Get(name)) {
+			return false
+		}
+	}
+
+	for name, matcher := range m.Cookies {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		if !matcher.Match(value) {
+			return false
+		}
+	}
+
+	if len(m.Session) > 0 {
+		values := sessions.Values(sessionID(r))
+		for name, matcher := range m.Session {
+			if !matcher.Match(matchableString(values[name])) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// matchableString turns a value into the string a Matcher is matched
+// against, objects and arrays are encoded as JSON.
+func matchableString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
+
+// matchesValue compares values decoded from JSON, scalars are also matched
+// by their string form so "1" matches 1 and "true" matches true.
+func matchesValue(expected, actual interface{}) bool {
+	if reflect.DeepEqual(expected, actual) {
+		return true
+	}
+	switch actual.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return fmt.Sprint(expected) == fmt.Sprint(actual)
+}
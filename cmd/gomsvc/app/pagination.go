@@ -0,0 +1,365 @@
+package app
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	PaginationStyleOffset = "offset"
+	PaginationStylePage   = "page"
+	PaginationStyleCursor = "cursor"
+
+	defaultPageSize    = 20
+	defaultMaxPageSize = 100
+	cursorPrefix       = "offset:"
+)
+
+// ErrInvalidPageRequest is returned when the query parameters of a request
+// do not describe a page, such as a cursor that was not handed out.
+var ErrInvalidPageRequest = errors.New("invalid page request")
+
+var defaultEnvelope = map[string]string{
+	"items": "items",
+	"total": "total",
+	"next":  "next",
+	"prev":  "prev",
+}
+
+type Pagination struct {
+	Data        interface{}       `json:"data"`
+	Style       string            `json:"style"`
+	DefaultSize int               `json:"default_size"`
+	MaxSize     int               `json:"max_size"`
+	Params      PaginationParams  `json:"params"`
+	Envelope    map[string]string `json:"envelope"`
+	Bare        bool              `json:"bare"`
+	Links       bool              `json:"links"`
+}
+
+type PaginationParams struct {
+	Offset string `json:"offset"`
+	Limit  string `json:"limit"`
+	Page   string `json:"page"`
+	Size   string `json:"size"`
+	Cursor string `json:"cursor"`
+}
+
+// page is a slice of the dataset together with what is needed to link to
+// the pages around it.
+type page struct {
+	items  []interface{}
+	total  int
+	offset int
+	size   int
+}
+
+// Paginate returns the body and headers for the page of the dataset that
+// the query parameters of the request asks for.
+func (p Pagination) Paginate(request *http.Request) (interface{}, http.Header, error) {
+	items, err := p.data()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	offset, size, err := p.window(request)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current := page{total: len(items), offset: offset, size: size, items: []interface{}{}}
+
+	if offset >= 0 && offset < len(items) {
+		end := offset + size
+		if end > len(items) {
+			end = len(items)
+		}
+		current.items = items[offset:end]
+	}
+
+	links := p.links(request, current)
+	headers := http.Header{}
+	headers.Set("X-Total-Count", strconv.Itoa(current.total))
+
+	if p.Links || p.Bare {
+		values := []string{}
+		for _, rel := range []string{"first", "prev", "next", "last"} {
+			if link, ok := links[rel]; ok {
+				values = append(values, "<"+link+`>; rel="`+rel+`"`)
+			}
+		}
+		if len(values) > 0 {
+			headers.Set("Link", strings.Join(values, ", "))
+		}
+	}
+
+	if p.Bare {
+		return current.items, headers, nil
+	}
+
+	return p.envelope(current, links), headers, nil
+}
+
+// envelope wraps the page in an object shaped by the configured envelope,
+// which maps fields such as items and total to where they are placed.
+func (p Pagination) envelope(current page, links map[string]string) map[string]interface{} {
+	envelope := p.Envelope
+	if envelope == nil {
+		envelope = defaultEnvelope
+	}
+
+	values := map[string]interface{}{
+		"items":  current.items,
+		"total":  current.total,
+		"offset": current.offset,
+		"limit":  current.size,
+		"size":   current.size,
+		"page":   current.offset/current.size + 1,
+		"pages":  (current.total + current.size - 1) / current.size,
+		"next":   nil,
+		"prev":   nil,
+	}
+
+	if next, ok := links["next"]; ok {
+		values["next"] = next
+		values["next_cursor"] = encodeCursor(current.offset + current.size)
+	}
+
+	if prev, ok := links["prev"]; ok {
+		values["prev"] = prev
+	}
+
+	body := map[string]interface{}{}
+
+	for field, path := range envelope {
+		if path == "" {
+			continue
+		}
+		if value, ok := values[field]; ok {
+			setField(body, path, value)
+		}
+	}
+
+	return body
+}
+
+// window returns the offset and size asked for by the request.
+func (p Pagination) window(request *http.Request) (int, int, error) {
+	query := request.URL.Query()
+	params := p.params()
+	sizeParam := params.Limit
+	if p.style() == PaginationStylePage {
+		sizeParam = params.Size
+	}
+
+	requested, err := queryInt(query, sizeParam)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := p.size(requested)
+	offset := 0
+
+	switch p.style() {
+	case PaginationStylePage:
+		n, err := queryInt(query, params.Page)
+		if err != nil {
+			return 0, 0, err
+		}
+		if n-1 > math.MaxInt/size {
+			return 0, 0, fmt.Errorf("%w, %s %d is out of range", ErrInvalidPageRequest, params.Page, n)
+		}
+		if n > 1 {
+			offset = (n - 1) * size
+		}
+	case PaginationStyleCursor:
+		if cursor := query.Get(params.Cursor); cursor != "" {
+			n, err := decodeCursor(cursor)
+			if err != nil {
+				return 0, 0, err
+			}
+			offset = n
+		}
+	default:
+		n, err := queryInt(query, params.Offset)
+		if err != nil {
+			return 0, 0, err
+		}
+		offset = n
+	}
+
+	// The end of the page has to be a number as well for it to be sliced
+	// and linked to
+	if offset > math.MaxInt-size {
+		return 0, 0, fmt.Errorf("%w, offset %d is out of range", ErrInvalidPageRequest, offset)
+	}
+
+	return offset, size, nil
+}
+
+// queryInt returns the query parameter name as a number, 0 when it is not
+// given.
+func queryInt(query url.Values, name string) (int, error) {
+	value := query.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%w, %s has to be a whole number of 0 or more", ErrInvalidPageRequest, name)
+	}
+	return n, nil
+}
+
+// links returns URLs for the pages around current, keyed by relation.
+func (p Pagination) links(request *http.Request, current page) map[string]string {
+	links := map[string]string{}
+	last := 0
+	if current.total > 0 {
+		last = (current.total - 1) / current.size * current.size
+	}
+
+	if p.style() != PaginationStyleCursor {
+		links["first"] = p.link(request, 0, current.size)
+		links["last"] = p.link(request, last, current.size)
+	}
+
+	if current.offset > 0 {
+		prev := current.offset - current.size
+		if prev < 0 {
+			prev = 0
+		}
+		links["prev"] = p.link(request, prev, current.size)
+	}
+
+	if current.offset+current.size < current.total {
+		links["next"] = p.link(request, current.offset+current.size, current.size)
+	}
+
+	return links
+}
+
+// link returns the URL of the request with its pagination parameters
+// replaced to point at offset.
+func (p Pagination) link(request *http.Request, offset, size int) string {
+	u := *request.URL
+	query := u.Query()
+	params := p.params()
+
+	switch p.style() {
+	case PaginationStylePage:
+		query.Set(params.Page, strconv.Itoa(offset/size+1))
+		query.Set(params.Size, strconv.Itoa(size))
+	case PaginationStyleCursor:
+		query.Set(params.Cursor, encodeCursor(offset))
+		query.Set(params.Limit, strconv.Itoa(size))
+	default:
+		query.Set(params.Offset, strconv.Itoa(offset))
+		query.Set(params.Limit, strconv.Itoa(size))
+	}
+
+	u.RawQuery = query.Encode()
+
+	// Incoming requests only carry the path, the host is added so the
+	// links can be followed as they are
+	if u.Host == "" {
+		u.Host = request.Host
+		u.Scheme = "http"
+		if request.TLS != nil {
+			u.Scheme = "https"
+		}
+	}
+
+	return u.String()
+}
+
+func (p Pagination) style() string {
+	if p.Style == "" {
+		return PaginationStyleOffset
+	}
+	return p.Style
+}
+
+func (p Pagination) size(requested int) int {
+	size := p.DefaultSize
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if requested > 0 {
+		size = requested
+	}
+	maxSize := p.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxPageSize
+	}
+	if size > maxSize {
+		size = maxSize
+	}
+	return size
+}
+
+func (p Pagination) params() PaginationParams {
+	params := p.Params
+	if params.Offset == "" {
+		params.Offset = "offset"
+	}
+	if params.Limit == "" {
+		params.Limit = "limit"
+	}
+	if params.Page == "" {
+		params.Page = "page"
+	}
+	if params.Size == "" {
+		params.Size = "size"
+	}
+	if params.Cursor == "" {
+		params.Cursor = "cursor"
+	}
+	return params
+}
+
+// data returns the dataset, either given inline or read from a file: path.
+func (p Pagination) data() ([]interface{}, error) {
+	if s, ok := p.Data.(string); ok && strings.HasPrefix(s, "file:") {
+		raw, err := os.ReadFile(strings.TrimPrefix(s, "file:"))
+		if err != nil {
+			return nil, err
+		}
+		items := []interface{}{}
+		if err := json.Unmarshal(raw, &items); err != nil {
+			return nil, errors.New("could not decode pagination data as a JSON array, " + err.Error())
+		}
+		return items, nil
+	}
+
+	if items, ok := p.Data.([]interface{}); ok {
+		return items, nil
+	}
+
+	return nil, errors.New("pagination data has to be an array or a file: path")
+}
+
+func encodeCursor(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.Itoa(offset)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil && strings.HasPrefix(string(data), cursorPrefix) {
+		if offset, err := strconv.Atoi(strings.TrimPrefix(string(data), cursorPrefix)); err == nil && offset >= 0 {
+			return offset, nil
+		}
+	}
+	return 0, fmt.Errorf("%w, invalid cursor %s", ErrInvalidPageRequest, cursor)
+}
@@ -0,0 +1,151 @@
+package app_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func paginationTestData(n int) []interface{} {
+	items := []interface{}{}
+	for i := 1; i <= n; i++ {
+		items = append(items, map[string]interface{}{"id": float64(i)})
+	}
+	return items
+}
+
+func servePaginated(t *testing.T, pagination app.Pagination, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	route := app.Route{
+		Name:   "list",
+		Path:   "/items",
+		Method: http.MethodGet,
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Pagination: &pagination,
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, target, nil))
+
+	body := map[string]interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &body)
+
+	return w, body
+}
+
+func TestThatOffsetPaginationReturnsEnvelope(t *testing.T) {
+	w, body := servePaginated(t, app.Pagination{Data: paginationTestData(25), DefaultSize: 10}, "/items?offset=10")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, paginationTestData(20)[10:], body["items"])
+	assert.Equal(t, float64(25), body["total"])
+	assert.Equal(t, "http://example.com/items?limit=10&offset=20", body["next"])
+	assert.Equal(t, "http://example.com/items?limit=10&offset=0", body["prev"])
+	assert.Equal(t, "25", w.Header().Get("X-Total-Count"))
+	assert.Empty(t, w.Header().Get("Link"))
+}
+
+func TestThatPagePaginationUsesConfiguredShapeAndLinks(t *testing.T) {
+	pagination := app.Pagination{
+		Data:     paginationTestData(25),
+		Style:    app.PaginationStylePage,
+		Params:   app.PaginationParams{Page: "p", Size: "per_page"},
+		Envelope: map[string]string{"items": "data", "total": "meta.total", "page": "meta.page"},
+		Links:    true,
+	}
+
+	w, body := servePaginated(t, pagination, "/items?p=3&per_page=10")
+
+	assert.Equal(t, paginationTestData(25)[20:], body["data"])
+	assert.Equal(t, map[string]interface{}{"total": float64(25), "page": float64(3)}, body["meta"])
+	assert.Nil(t, body["next"])
+	assert.Equal(t,
+		`<http://example.com/items?p=1&per_page=10>; rel="first", `+
+			`<http://example.com/items?p=2&per_page=10>; rel="prev", `+
+			`<http://example.com/items?p=3&per_page=10>; rel="last"`,
+		w.Header().Get("Link"))
+}
+
+func TestThatCursorPaginationFollowsCursors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "items.json")
+	data, _ := json.Marshal(paginationTestData(5))
+	os.WriteFile(path, data, 0o644)
+
+	pagination := app.Pagination{
+		Data:     "file:" + path,
+		Style:    app.PaginationStyleCursor,
+		Envelope: map[string]string{"items": "items", "next_cursor": "cursor"},
+	}
+
+	target := "/items?limit=2"
+	seen := []interface{}{}
+
+	for i := 0; i < 3; i++ {
+		_, body := servePaginated(t, pagination, target)
+		seen = append(seen, body["items"].([]interface{})...)
+		cursor, ok := body["cursor"].(string)
+		if !ok {
+			break
+		}
+		target = "/items?limit=2&cursor=" + cursor
+	}
+
+	assert.Equal(t, paginationTestData(5), seen)
+
+	w, _ := servePaginated(t, pagination, "/items?cursor=garbage")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestThatBarePaginationReturnsArrayWithLinkHeader(t *testing.T) {
+	route := app.Route{
+		Name:   "list",
+		Path:   "/items",
+		Method: http.MethodGet,
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"content-type": "application/json"},
+			Pagination: &app.Pagination{Data: paginationTestData(3), DefaultSize: 2, MaxSize: 2, Bare: true},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/items?limit=50", nil))
+
+	items := []interface{}{}
+	json.Unmarshal(w.Body.Bytes(), &items)
+
+	assert.Equal(t, paginationTestData(2), items)
+	assert.Contains(t, w.Header().Get("Link"), `<http://example.com/items?limit=2&offset=2>; rel="next"`)
+}
+
+func TestThatPaginationErrorsSeparateBadRequestsFromBadData(t *testing.T) {
+	tests := []struct {
+		name       string
+		pagination app.Pagination
+		target     string
+		status     int
+	}{
+		{name: "invalid offset", pagination: app.Pagination{Data: paginationTestData(3)}, target: "/items?offset=abc", status: http.StatusBadRequest},
+		{name: "negative limit", pagination: app.Pagination{Data: paginationTestData(3)}, target: "/items?limit=-1", status: http.StatusBadRequest},
+		{name: "invalid page", pagination: app.Pagination{Data: paginationTestData(3), Style: app.PaginationStylePage}, target: "/items?page=first", status: http.StatusBadRequest},
+		{name: "page out of range", pagination: app.Pagination{Data: paginationTestData(3), Style: app.PaginationStylePage}, target: "/items?page=922337203685477581", status: http.StatusBadRequest},
+		{name: "offset out of range", pagination: app.Pagination{Data: paginationTestData(3)}, target: "/items?offset=9223372036854775807", status: http.StatusBadRequest},
+		{name: "missing data file", pagination: app.Pagination{Data: "file:" + filepath.Join(t.TempDir(), "missing.json")}, target: "/items", status: http.StatusInternalServerError},
+		{name: "data not an array", pagination: app.Pagination{Data: map[string]interface{}{"id": 1}}, target: "/items", status: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, _ := servePaginated(t, tt.pagination, tt.target)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
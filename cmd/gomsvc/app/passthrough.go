@@ -0,0 +1,125 @@
+package app
+
+import (
+	"net/http"
+)
+
+const (
+	PassthroughStatusWorst      = "worst"
+	PassthroughStatusAnyFailure = "any_failure"
+
+	defaultPassthroughFailureStatus = http.StatusBadGateway
+)
+
+// headers that describe how an upstream response was transferred and not
+// the response itself, copying them would corrupt the route response
+var nonPassthroughHeaders = map[string]bool{
+	"Connection":        true,
+	"Content-Length":    true,
+	"Content-Encoding":  true,
+	"Transfer-Encoding": true,
+}
+
+// Passthrough decides how the status code and headers of upstream responses
+// carry over to the route response.
+type Passthrough struct {
+	Upstream      *int     `json:"upstream"`
+	Headers       []string `json:"headers"`
+	Status        string   `json:"status"`
+	FailureStatus int      `json:"failure_status"`
+	ErrorStatus   int      `json:"error_status"`
+}
+
+// statusCode returns the status code of the route response given the
+// results of the upstreams, configured is used when no rule applies.
+func (p Passthrough) statusCode(results []upstreamResult, configured int) int {
+	switch p.Status {
+	case PassthroughStatusWorst:
+		worst := 0
+		for _, result := range results {
+			if result.skipped {
+				continue
+			}
+			if status := p.resultStatus(result); status > worst {
+				worst = status
+			}
+		}
+		if worst >= http.StatusBadRequest {
+			return worst
+		}
+	case PassthroughStatusAnyFailure:
+		for _, result := range results {
+			if !result.skipped && result.failed() {
+				return p.failureStatus()
+			}
+		}
+	default:
+		if result, ok := p.chosen(results); ok && !result.skipped {
+			return p.resultStatus(result)
+		}
+		if p.ErrorStatus != 0 {
+			for _, result := range results {
+				if result.err != nil {
+					return p.ErrorStatus
+				}
+			}
+		}
+	}
+
+	return configured
+}
+
+// header returns the configured headers taken from the chosen upstream, or
+// from the first upstream that has them when no upstream is chosen.
+func (p Passthrough) header(results []upstreamResult) http.Header {
+	headers := http.Header{}
+
+	candidates := results
+	if result, ok := p.chosen(results); ok {
+		candidates = []upstreamResult{result}
+	}
+
+	for _, name := range p.Headers {
+		name = http.CanonicalHeaderKey(name)
+		if nonPassthroughHeaders[name] {
+			continue
+		}
+		for _, result := range candidates {
+			if result.response == nil {
+				continue
+			}
+			if values, ok := result.response.Header[name]; ok {
+				headers[name] = values
+				break
+			}
+		}
+	}
+
+	return headers
+}
+
+func (p Passthrough) chosen(results []upstreamResult) (upstreamResult, bool) {
+	if p.Upstream == nil || *p.Upstream < 0 || *p.Upstream >= len(results) {
+		return upstreamResult{}, false
+	}
+	return results[*p.Upstream], true
+}
+
+// resultStatus returns the status code of an upstream result that was
+// called, upstreams that could not be reached count as the error status.
+func (p Passthrough) resultStatus(result upstreamResult) int {
+	if result.err != nil {
+		if p.ErrorStatus != 0 {
+			return p.ErrorStatus
+		}
+		return defaultPassthroughFailureStatus
+	}
+	return result.response.StatusCode
+}
+
+func (p Passthrough) failureStatus() int {
+	if p.FailureStatus == 0 {
+		return defaultPassthroughFailureStatus
+	}
+	return p.FailureStatus
+}
@@ -0,0 +1,129 @@
+package app_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func statusServer(t *testing.T, status int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Upstream-Status", http.StatusText(status))
+		w.Header().Set("X-Request-Id", "abc")
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestThatHandlerPassesThroughUpstreamStatus(t *testing.T) {
+	ok := statusServer(t, http.StatusOK)
+	notFound := statusServer(t, http.StatusNotFound)
+	failing := statusServer(t, http.StatusServiceUnavailable)
+	unreachable := "http://127.0.0.1:1"
+	second := 1
+
+	tests := []struct {
+		name        string
+		urls        []string
+		passthrough app.Passthrough
+		status      int
+	}{
+		{name: "chosen upstream", urls: []string{ok.URL, notFound.URL}, passthrough: app.Passthrough{Upstream: &second}, status: http.StatusNotFound},
+		{name: "chosen upstream out of range", urls: []string{ok.URL}, passthrough: app.Passthrough{Upstream: &second}, status: http.StatusCreated},
+		{name: "worst status wins", urls: []string{notFound.URL, failing.URL, ok.URL}, passthrough: app.Passthrough{Status: app.PassthroughStatusWorst}, status: http.StatusServiceUnavailable},
+		{name: "worst status keeps configured on success", urls: []string{ok.URL}, passthrough: app.Passthrough{Status: app.PassthroughStatusWorst}, status: http.StatusCreated},
+		{name: "any failure", urls: []string{ok.URL, failing.URL}, passthrough: app.Passthrough{Status: app.PassthroughStatusAnyFailure}, status: http.StatusBadGateway},
+		{name: "any failure with custom status", urls: []string{ok.URL, unreachable}, passthrough: app.Passthrough{Status: app.PassthroughStatusAnyFailure, FailureStatus: http.StatusServiceUnavailable}, status: http.StatusServiceUnavailable},
+		{name: "any failure ignores client errors", urls: []string{notFound.URL}, passthrough: app.Passthrough{Status: app.PassthroughStatusAnyFailure}, status: http.StatusCreated},
+		{name: "transport error status", urls: []string{ok.URL, unreachable}, passthrough: app.Passthrough{ErrorStatus: http.StatusGatewayTimeout}, status: http.StatusGatewayTimeout},
+		{name: "chosen unreachable upstream", urls: []string{ok.URL, unreachable}, passthrough: app.Passthrough{Upstream: &second}, status: http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstreams := []app.Upstream{}
+			for _, url := range tt.urls {
+				upstreams = append(upstreams, app.Upstream{URL: url, Method: http.MethodGet})
+			}
+			passthrough := tt.passthrough
+			route := app.Route{
+				Name:      "passthrough",
+				Path:      "/",
+				Method:    http.MethodGet,
+				Upstreams: upstreams,
+				Response:  app.Response{StatusCode: http.StatusCreated, Passthrough: &passthrough},
+			}
+
+			w := httptest.NewRecorder()
+			app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestThatHandlerPassesThroughUpstreamHeaders(t *testing.T) {
+	ok := statusServer(t, http.StatusOK)
+	notFound := statusServer(t, http.StatusNotFound)
+	second := 1
+
+	route := app.Route{
+		Name:      "passthrough",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{{URL: ok.URL, Method: http.MethodGet}, {URL: notFound.URL, Method: http.MethodGet}},
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Headers:    map[string]string{"X-Upstream-Status": "configured"},
+			Passthrough: &app.Passthrough{
+				Upstream: &second,
+				Headers:  []string{"x-upstream-status", "X-Request-Id", "Content-Length", "X-Missing"},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "Not Found", w.Header().Get("X-Upstream-Status"))
+	assert.Equal(t, "abc", w.Header().Get("X-Request-Id"))
+	assert.Empty(t, w.Header().Get("Content-Length"))
+	assert.NotContains(t, w.Header(), "X-Missing")
+}
+
+func TestThatHandlerPassesThroughStatusOfConditionalUpstreams(t *testing.T) {
+	ok := statusServer(t, http.StatusOK)
+	failing := statusServer(t, http.StatusServiceUnavailable)
+	first := 0
+
+	for _, passthrough := range []app.Passthrough{
+		{Upstream: &first},
+		{Status: app.PassthroughStatusWorst},
+		{Status: app.PassthroughStatusAnyFailure},
+	} {
+		passthrough := passthrough
+		route := app.Route{
+			Name:   "passthrough",
+			Path:   "/",
+			Method: http.MethodGet,
+			Upstreams: []app.Upstream{
+				{URL: failing.URL, Method: http.MethodGet, If: `{{.Request.Query.Get "call"}}`},
+				{URL: ok.URL, Method: http.MethodGet},
+			},
+			Response: app.Response{StatusCode: http.StatusCreated, Passthrough: &passthrough},
+		}
+
+		w := httptest.NewRecorder()
+		app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		assert.Equal(t, http.StatusCreated, w.Code)
+
+		w = httptest.NewRecorder()
+		app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/?call=true", nil))
+		assert.NotEqual(t, http.StatusCreated, w.Code)
+	}
+}
@@ -0,0 +1,165 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+	"github.com/inquizarus/gomsvc/pkg/logging"
+)
+
+// Proxy forwards requests to Target and streams the responses back, with
+// the path rewritten by the rewrite rules and the response optionally
+// changed by the overrides. Header overrides with an empty value remove the
+// header.
+type Proxy struct {
+	Target          string                 `json:"target"`
+	Rewrite         []ProxyRewrite         `json:"rewrite"`
+	Headers         map[string]string      `json:"headers"`
+	ResponseHeaders map[string]string      `json:"response_headers"`
+	StatusCode      int                    `json:"status_code"`
+	Fields          map[string]interface{} `json:"fields"`
+}
+
+// ProxyRewrite replaces every match of the regular expression Pattern in
+// the request path with Replacement, which can refer to groups as $1.
+type ProxyRewrite struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+type compiledRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// Handler returns the handler that proxies requests, configuration errors
+// are returned so they can be reported when the route is set up.
+func (p Proxy) Handler(log logging.Logger) (http.Handler, error) {
+	target, err := url.Parse(p.target())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Scheme == "" || target.Host == "" {
+		return nil, errors.New("proxy target " + p.Target + " has to be an absolute URL")
+	}
+
+	rewrites := make([]compiledRewrite, len(p.Rewrite))
+	for i, rewrite := range p.Rewrite {
+		pattern, err := regexp.Compile(rewrite.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		rewrites[i] = compiledRewrite{pattern: pattern, replacement: rewrite.Replacement}
+	}
+
+	return &httputil.ReverseProxy{
+		// Responses are flushed as they are received so streams such as
+		// Server-Sent Events pass through unbuffered
+		FlushInterval: -1,
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			path := pr.In.URL.Path
+			for _, rewrite := range rewrites {
+				path = rewrite.pattern.ReplaceAllString(path, rewrite.replacement)
+			}
+
+			pr.SetURL(target)
+			pr.Out.URL.Path = joinPath(target.Path, path)
+			pr.Out.URL.RawPath = ""
+			pr.Out.Host = target.Host
+			pr.SetXForwarded()
+
+			for k, v := range p.Headers {
+				if v == "" {
+					pr.Out.Header.Del(k)
+					continue
+				}
+				pr.Out.Header.Set(k, v)
+			}
+
+			// Fields can only be changed in bodies that are not compressed
+			if len(p.Fields) > 0 {
+				pr.Out.Header.Del("Accept-Encoding")
+			}
+		},
+		ModifyResponse: p.modifyResponse,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Info("could not proxy request to " + r.URL.Path + ", " + err.Error())
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}, nil
+}
+
+// modifyResponse applies the overrides to the response from the target,
+// JSON bodies are only buffered when there are fields to change.
+func (p Proxy) modifyResponse(response *http.Response) error {
+	if p.StatusCode != 0 {
+		response.StatusCode = p.StatusCode
+		response.Status = strconv.Itoa(p.StatusCode) + " " + http.StatusText(p.StatusCode)
+	}
+
+	for k, v := range p.ResponseHeaders {
+		if v == "" {
+			response.Header.Del(k)
+			continue
+		}
+		response.Header.Set(k, v)
+	}
+
+	if len(p.Fields) == 0 || !httptools.IsJSON(response.Header) {
+		return nil
+	}
+
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	if err != nil {
+		return err
+	}
+
+	var body map[string]interface{}
+
+	if err := json.Unmarshal(data, &body); err == nil {
+		fields, err := renderTemplateValues(p.Fields, newTemplateData(response.Request, false))
+		if err != nil {
+			return err
+		}
+		for path, value := range fields.(map[string]interface{}) {
+			setField(body, path, value)
+		}
+		if data, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	response.ContentLength = int64(len(data))
+	response.Header.Set("Content-Length", strconv.Itoa(len(data)))
+
+	return nil
+}
+
+func (p Proxy) target() string {
+	if strings.HasPrefix(p.Target, "env:") {
+		return os.Getenv(strings.TrimPrefix(p.Target, "env:"))
+	}
+	return p.Target
+}
+
+func joinPath(base, path string) string {
+	if base == "" {
+		return path
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(path, "/")
+}
@@ -0,0 +1,165 @@
+package app_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/inquizarus/rwapper/v2/pkg/servemuxwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+func echoServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("X-Backend", "echo")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"method":  r.Method,
+			"path":    r.URL.Path,
+			"query":   r.URL.RawQuery,
+			"body":    string(body),
+			"headers": r.Header,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestThatProxyForwardsRequests(t *testing.T) {
+	backend := echoServer(t)
+	route := app.Route{
+		Name:   "proxy",
+		Path:   "/",
+		Method: app.AnyMethod,
+		Kind:   app.RouteKindProxy,
+		Proxy: &app.Proxy{
+			Target:  backend.URL + "/base",
+			Rewrite: []app.ProxyRewrite{{Pattern: "^/v1/(.*)$", Replacement: "/api/$1"}},
+			Headers: map[string]string{"X-Added": "yes", "X-Removed": ""},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPatch, "/v1/users/1?expand=true", strings.NewReader(`{"name": "john"}`))
+	r.Header.Set("X-Removed", "secret")
+	r.Header.Set("X-Kept", "kept")
+	r.Header.Set("Connection", "X-Hop")
+	r.Header.Set("X-Hop", "hop")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, r)
+
+	var echoed struct {
+		Method  string              `json:"method"`
+		Path    string              `json:"path"`
+		Query   string              `json:"query"`
+		Body    string              `json:"body"`
+		Headers map[string][]string `json:"headers"`
+	}
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &echoed))
+	assert.Equal(t, http.MethodPatch, echoed.Method)
+	assert.Equal(t, "/base/api/users/1", echoed.Path)
+	assert.Equal(t, "expand=true", echoed.Query)
+	assert.Equal(t, `{"name": "john"}`, echoed.Body)
+	assert.Equal(t, []string{"kept"}, echoed.Headers["X-Kept"])
+	assert.Equal(t, []string{"yes"}, echoed.Headers["X-Added"])
+	assert.NotContains(t, echoed.Headers, "X-Removed")
+	assert.NotContains(t, echoed.Headers, "X-Hop")
+	assert.NotContains(t, echoed.Headers, "Keep-Alive")
+	assert.Contains(t, echoed.Headers, "X-Forwarded-For")
+	assert.Equal(t, "echo", w.Header().Get("X-Backend"))
+}
+
+func TestThatProxyOverridesResponses(t *testing.T) {
+	backend := echoServer(t)
+	route := app.Route{
+		Name:   "proxy",
+		Path:   "/",
+		Method: http.MethodGet,
+		Kind:   app.RouteKindProxy,
+		Proxy: &app.Proxy{
+			Target:          backend.URL,
+			StatusCode:      http.StatusTeapot,
+			ResponseHeaders: map[string]string{"X-Backend": "", "X-Proxied": "true"},
+			Fields:          map[string]interface{}{"method": "OVERRIDDEN", "extra.path": "{{.Request.Path}}"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, http.StatusTeapot, w.Code)
+	assert.Empty(t, w.Header().Get("X-Backend"))
+	assert.Equal(t, "true", w.Header().Get("X-Proxied"))
+	assert.Equal(t, "OVERRIDDEN", body["method"])
+	assert.Equal(t, "/users", body["path"])
+	assert.Equal(t, map[string]interface{}{"path": "/users"}, body["extra"])
+}
+
+func TestThatProxyStreamsResponses(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: second\n\n"))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(app.Config{Routes: []app.Route{
+		{Name: "mocked", Path: "/mocked", Method: http.MethodGet, Response: app.Response{StatusCode: http.StatusOK, Body: "mocked"}},
+		{Name: "proxy", Path: "/", Method: app.AnyMethod, Kind: app.RouteKindProxy, Proxy: &app.Proxy{Target: backend.URL}},
+	}}, router, testLogger)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/mocked")
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+	assert.Equal(t, "mocked", string(data))
+
+	response, err = http.Get(server.URL + "/events")
+	assert.NoError(t, err)
+	defer response.Body.Close()
+
+	lines := make(chan string)
+	go func() {
+		line, _ := bufio.NewReader(response.Body).ReadString('\n')
+		lines <- line
+	}()
+
+	select {
+	case line := <-lines:
+		assert.Equal(t, "data: first\n", line)
+	case <-time.After(time.Second):
+		t.Fatal("first event was not streamed before the backend finished")
+	}
+}
+
+func TestThatProxyWithUnreachableTargetAnswersBadGateway(t *testing.T) {
+	route := app.Route{
+		Name:   "proxy",
+		Path:   "/",
+		Method: http.MethodGet,
+		Kind:   app.RouteKindProxy,
+		Proxy:  &app.Proxy{Target: "http://127.0.0.1:1"},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+}
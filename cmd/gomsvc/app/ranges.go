@@ -0,0 +1,32 @@
+package app
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+)
+
+// filePath returns the path of a file: body, empty for any other body.
+func (r Response) filePath() string {
+	if body, ok := r.Body.(string); ok && strings.HasPrefix(body, "file:") {
+		return strings.TrimPrefix(body, "file:")
+	}
+	return ""
+}
+
+// servesRanges reports whether Range requests are supported, which is only
+// the case for successful file: responses.
+func (r Response) servesRanges() bool {
+	return !r.DisableRanges && r.filePath() != "" && (r.StatusCode == http.StatusOK || r.StatusCode == 0)
+}
+
+// serveRanges serves data with support for single and multiple ranges,
+// If-Range and 416 for unsatisfiable ranges. The validators set on w are
+// used when evaluating If-Range.
+func (r Response) serveRanges(w http.ResponseWriter, request *http.Request, data []byte) {
+	if r.Throttle != nil {
+		w = r.Throttle.Writer(w, request)
+	}
+	lastModified, _ := r.lastModified()
+	http.ServeContent(w, request, r.filePath(), lastModified, bytes.NewReader(data))
+}
@@ -0,0 +1,154 @@
+package app_test
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatFileResponsesSupportRangeRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.txt")
+	os.WriteFile(path, []byte("0123456789abcdefghij"), 0o644)
+
+	tests := []struct {
+		name          string
+		disableRanges bool
+		headers       map[string]string
+		status        int
+		body          string
+		contentRange  string
+		acceptRanges  string
+	}{
+		{
+			name:         "no range",
+			status:       http.StatusOK,
+			body:         "0123456789abcdefghij",
+			acceptRanges: "bytes",
+		},
+		{
+			name:         "single range",
+			headers:      map[string]string{"Range": "bytes=5-9"},
+			status:       http.StatusPartialContent,
+			body:         "56789",
+			contentRange: "bytes 5-9/20",
+			acceptRanges: "bytes",
+		},
+		{
+			name:         "suffix range",
+			headers:      map[string]string{"Range": "bytes=-3"},
+			status:       http.StatusPartialContent,
+			body:         "hij",
+			contentRange: "bytes 17-19/20",
+			acceptRanges: "bytes",
+		},
+		{
+			name:         "unsatisfiable range",
+			headers:      map[string]string{"Range": "bytes=50-60"},
+			status:       http.StatusRequestedRangeNotSatisfiable,
+			contentRange: "bytes */20",
+		},
+		{
+			name:         "if-range with current etag",
+			headers:      map[string]string{"Range": "bytes=0-1", "If-Range": `"v1"`},
+			status:       http.StatusPartialContent,
+			body:         "01",
+			contentRange: "bytes 0-1/20",
+			acceptRanges: "bytes",
+		},
+		{
+			name:         "if-range with stale etag",
+			headers:      map[string]string{"Range": "bytes=0-1", "If-Range": `"v0"`},
+			status:       http.StatusOK,
+			body:         "0123456789abcdefghij",
+			acceptRanges: "bytes",
+		},
+		{
+			name:          "ranges disabled",
+			disableRanges: true,
+			headers:       map[string]string{"Range": "bytes=5-9"},
+			status:        http.StatusOK,
+			body:          "0123456789abcdefghij",
+			acceptRanges:  "none",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := app.Route{
+				Name:   tt.name,
+				Path:   "/",
+				Method: http.MethodGet,
+				Response: app.Response{
+					StatusCode:    http.StatusOK,
+					Body:          "file:" + path,
+					ETag:          "v1",
+					DisableRanges: tt.disableRanges,
+				},
+			}
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, r)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.contentRange, w.Header().Get("Content-Range"))
+			assert.Equal(t, tt.acceptRanges, w.Header().Get("Accept-Ranges"))
+			if tt.status != http.StatusRequestedRangeNotSatisfiable {
+				assert.Equal(t, tt.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestThatFileResponsesSupportMultipleRanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	os.WriteFile(path, []byte("0123456789abcdefghij"), 0o644)
+
+	route := app.Route{
+		Name:     "multi",
+		Path:     "/",
+		Method:   http.MethodGet,
+		Response: app.Response{StatusCode: http.StatusOK, Body: "file:" + path},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Range", "bytes=0-1,10-12")
+
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, r)
+
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+
+	mediaType, params, err := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/byteranges", mediaType)
+
+	reader := multipart.NewReader(w.Body, params["boundary"])
+	parts := []string{}
+	ranges := []string{}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(part)
+		parts = append(parts, string(data))
+		ranges = append(ranges, part.Header.Get("Content-Range"))
+	}
+
+	assert.Equal(t, []string{"01", "abc"}, parts)
+	assert.Equal(t, []string{"bytes 0-1/20", "bytes 10-12/20"}, ranges)
+}
@@ -0,0 +1,442 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+	"github.com/inquizarus/gomsvc/pkg/logging"
+	"github.com/inquizarus/rwapper/v2"
+)
+
+const (
+	defaultRecordDir         = "routes"
+	defaultRecordMaxBodySize = 16 * 1024
+	defaultRedaction         = "REDACTED"
+	recordBodiesDir          = "bodies"
+)
+
+// headers that change on every response and would only add noise to
+// recorded routes
+var volatileHeaders = []string{
+	"Age",
+	"Alt-Svc",
+	"Connection",
+	"Content-Length",
+	"Date",
+	"Keep-Alive",
+	"Server-Timing",
+	"Traceparent",
+	"Transfer-Encoding",
+	"Via",
+	"X-Correlation-Id",
+	"X-Request-Id",
+}
+
+// headers that carry credentials and are always redacted
+var secretHeaders = []string{
+	"Authorization",
+	"Cookie",
+	"Proxy-Authorization",
+	"Set-Cookie",
+	"X-Api-Key",
+}
+
+type recordContextKey struct{}
+
+// RecordConfig turns on record mode, where every request is forwarded to
+// Target and each distinct request is written to Dir as a route that
+// replays the response. Requests are distinct when their method, path and
+// the values of the query parameters and headers in MatchQuery and
+// MatchHeaders differ, all query parameters are used when MatchQuery is
+// left out.
+type RecordConfig struct {
+	Target       string       `json:"target"`
+	Dir          string       `json:"dir"`
+	MatchQuery   []string     `json:"match_query"`
+	MatchHeaders []string     `json:"match_headers"`
+	StripHeaders []string     `json:"strip_headers"`
+	MaxBodySize  int          `json:"max_body_size"`
+	Redact       []RedactRule `json:"redact"`
+}
+
+// RedactRule replaces secrets in recorded routes. Header redacts a header,
+// Field redacts the value at a dot separated path in JSON bodies and Pattern
+// redacts every match of a regular expression in bodies and headers.
+type RedactRule struct {
+	Header      string `json:"header"`
+	Field       string `json:"field"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// Recorder forwards requests to the target of the configuration and
+// records the responses as routes.
+type Recorder struct {
+	config   RecordConfig
+	proxy    http.Handler
+	patterns []*regexp.Regexp
+	log      logging.Logger
+	mu       sync.Mutex
+}
+
+// recordedRequest is what is needed from the incoming request when its
+// response is recorded.
+type recordedRequest struct {
+	method string
+	path   string
+	query  url.Values
+	header http.Header
+}
+
+func NewRecorder(config RecordConfig, log logging.Logger) (*Recorder, error) {
+	recorder := &Recorder{config: config, log: log}
+
+	for _, rule := range config.Redact {
+		if rule.Pattern == "" {
+			recorder.patterns = append(recorder.patterns, nil)
+			continue
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		recorder.patterns = append(recorder.patterns, pattern)
+	}
+
+	// Bodies are asked for without compression so they can be recorded
+	handler, err := Proxy{Target: config.Target, Headers: map[string]string{"Accept-Encoding": ""}}.Handler(log)
+
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := handler.(*httputil.ReverseProxy)
+	proxy.ModifyResponse = recorder.record
+	recorder.proxy = proxy
+
+	return recorder, nil
+}
+
+// RegisterRecorder serves every path of router with a recorder.
+func RegisterRecorder(config RecordConfig, router rwapper.RouterWrapper, log logging.Logger) error {
+	recorder, err := NewRecorder(config, log)
+
+	if err != nil {
+		return err
+	}
+
+	register(router, anyMethods, "/", recorder.ServeHTTP)
+
+	log.Info("recording requests to " + config.Target + " into " + recorder.dir())
+
+	return nil
+}
+
+func (rec *Recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	request := &recordedRequest{
+		method: r.Method,
+		path:   r.URL.Path,
+		query:  r.URL.Query(),
+		header: r.Header.Clone(),
+	}
+	rec.proxy.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), recordContextKey{}, request)))
+}
+
+// record writes the route for the response, the body is read and put back
+// so it is still sent to the client.
+func (rec *Recorder) record(response *http.Response) error {
+	request, ok := response.Request.Context().Value(recordContextKey{}).(*recordedRequest)
+
+	if !ok {
+		return nil
+	}
+
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(data))
+
+	if err != nil {
+		return err
+	}
+
+	if err := rec.write(request, response, data); err != nil {
+		rec.log.Error("could not record " + request.method + " " + request.path + ", " + err.Error())
+	}
+
+	return nil
+}
+
+func (rec *Recorder) write(request *recordedRequest, response *http.Response, data []byte) error {
+	match := rec.matcher(request)
+	name := routeName(request.method, request.path, match)
+
+	body, err := rec.body(name, response.Header, data)
+
+	if err != nil {
+		return err
+	}
+
+	route := map[string]interface{}{
+		"name":   name,
+		"path":   request.path,
+		"method": request.method,
+		"response": map[string]interface{}{
+			"status_code": response.StatusCode,
+			"headers":     rec.headers(response.Header),
+			"body":        body,
+		},
+	}
+
+	if len(match) > 0 {
+		route["match"] = match
+	}
+
+	routeData, err := json.MarshalIndent(route, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	if err := os.MkdirAll(rec.dir(), 0o755); err != nil {
+		return err
+	}
+
+	// Requests that would be served by the same route are grouped into one
+	// file, the latest response wins
+	path := filepath.Join(rec.dir(), name+".json")
+	_, err = os.Stat(path)
+	if err == nil {
+		rec.log.Info("updating recorded route " + path)
+	} else {
+		rec.log.Info("recording route " + path)
+	}
+
+	return os.WriteFile(path, append(routeData, '\n'), 0o644)
+}
+
+// matcher returns the request matcher of the recorded route, built from
+// the query parameters and headers that make requests distinct.
+func (rec *Recorder) matcher(request *recordedRequest) map[string]interface{} {
+	match := map[string]interface{}{}
+
+	names := rec.config.MatchQuery
+	if names == nil {
+		for name := range request.query {
+			names = append(names, name)
+		}
+	}
+
+	query := map[string]interface{}{}
+	for _, name := range names {
+		query[name] = map[string]string{"exact": request.query.Get(name)}
+	}
+
+	headers := map[string]interface{}{}
+	for _, name := range rec.config.MatchHeaders {
+		headers[name] = map[string]string{"exact": rec.redactHeader(name, request.header.Get(name))}
+	}
+
+	if len(query) > 0 {
+		match["query"] = query
+	}
+
+	if len(headers) > 0 {
+		match["headers"] = headers
+	}
+
+	return match
+}
+
+// headers returns the response headers to record, without volatile headers
+// and with secrets redacted.
+func (rec *Recorder) headers(header http.Header) map[string]string {
+	strip := map[string]bool{}
+	for _, name := range append(volatileHeaders, rec.config.StripHeaders...) {
+		strip[http.CanonicalHeaderKey(name)] = true
+	}
+
+	headers := map[string]string{}
+	for name, values := range header {
+		if strip[name] {
+			continue
+		}
+		headers[strings.ToLower(name)] = rec.redactHeader(name, strings.Join(values, ", "))
+	}
+
+	return headers
+}
+
+// body returns the body to record, JSON is kept as a value and text as a
+// string. Large and binary bodies are written to their own file and
+// referenced with file:.
+func (rec *Recorder) body(name string, header http.Header, data []byte) (interface{}, error) {
+	if httptools.IsJSON(header) {
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err == nil {
+			value = rec.redactValue(value, "")
+			if encoded, _ := json.Marshal(value); len(encoded) <= rec.maxBodySize() {
+				return value, nil
+			}
+			data, _ = json.MarshalIndent(value, "", "    ")
+		}
+	} else if utf8.Valid(data) {
+		data = []byte(rec.redactText(string(data)))
+		if len(data) <= rec.maxBodySize() {
+			return string(data), nil
+		}
+	}
+
+	dir := filepath.Join(rec.dir(), recordBodiesDir)
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, name+bodyExtension(header.Get("Content-Type")))
+
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, err
+	}
+
+	return "file:" + path, nil
+}
+
+func (rec *Recorder) redactHeader(name, value string) string {
+	for _, secret := range secretHeaders {
+		if strings.EqualFold(secret, name) {
+			return defaultRedaction
+		}
+	}
+	for _, rule := range rec.config.Redact {
+		if rule.Header != "" && strings.EqualFold(rule.Header, name) {
+			return rule.replacement()
+		}
+	}
+	return rec.redactText(value)
+}
+
+// redactValue redacts fields and patterns in a decoded JSON value, path is
+// the dot separated path of value in the body.
+func (rec *Recorder) redactValue(value interface{}, path string) interface{} {
+	for _, rule := range rec.config.Redact {
+		if rule.Field != "" && rule.Field == path {
+			return rule.replacement()
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = rec.redactValue(item, strings.TrimPrefix(path+"."+key, "."))
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = rec.redactValue(item, path)
+		}
+	case string:
+		return rec.redactText(v)
+	}
+
+	return value
+}
+
+func (rec *Recorder) redactText(text string) string {
+	for i, pattern := range rec.patterns {
+		if pattern != nil {
+			text = pattern.ReplaceAllString(text, rec.config.Redact[i].replacement())
+		}
+	}
+	return text
+}
+
+func (rec *Recorder) dir() string {
+	if rec.config.Dir != "" {
+		return rec.config.Dir
+	}
+	if dir := os.Getenv(envKeyRoutesDir); dir != "" {
+		return dir
+	}
+	return defaultRecordDir
+}
+
+func (rec *Recorder) maxBodySize() int {
+	if rec.config.MaxBodySize <= 0 {
+		return defaultRecordMaxBodySize
+	}
+	return rec.config.MaxBodySize
+}
+
+func (r RedactRule) replacement() string {
+	if r.Replacement == "" {
+		return defaultRedaction
+	}
+	return r.Replacement
+}
+
+var unsafeNameCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// routeName returns a file system safe name for the route. A hash of the
+// path and matchers is appended when the path can not be told from the name,
+// such as /a-b and /a/b, or when there are matchers so routes on the same
+// path with different matchers get files of their own.
+func routeName(method, path string, match map[string]interface{}) string {
+	name := strings.Trim(unsafeNameCharacters.ReplaceAllString(strings.ToLower(path), "-"), "-")
+	lossless := "/"+strings.ReplaceAll(name, "-", "/") == path
+
+	if name == "" {
+		name = "root"
+	}
+
+	name = strings.ToLower(method) + "-" + name
+
+	if !lossless || len(match) > 0 {
+		data, _ := json.Marshal(match)
+		sum := sha256.Sum256(append([]byte(path+"\n"), data...))
+		name += "-" + hex.EncodeToString(sum[:4])
+	}
+
+	return name
+}
+
+func bodyExtension(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	extensions := map[string]string{
+		"application/json": ".json",
+		"application/xml":  ".xml",
+		"text/xml":         ".xml",
+		"text/html":        ".html",
+		"text/plain":       ".txt",
+		"text/csv":         ".csv",
+	}
+
+	if extension, ok := extensions[mediaType]; ok {
+		return extension
+	}
+
+	if extensions, _ := mime.ExtensionsByType(mediaType); len(extensions) > 0 {
+		sort.Strings(extensions)
+		return extensions[0]
+	}
+
+	return ".bin"
+}
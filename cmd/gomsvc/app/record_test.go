@@ -0,0 +1,189 @@
+package app_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/inquizarus/rwapper/v2/pkg/servemuxwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+func recordedBackend(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "application/json")
+		w.Header().Set("X-Request-Id", "volatile")
+		w.Header().Set("Set-Cookie", "session=abc")
+		w.Header().Set("X-Custom", "token secret-abc")
+		w.Write([]byte(`{"page": "` + r.URL.Query().Get("page") + `", "token": "secret-abc", "user": {"name": "john", "password": "pw"}}`))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/plain")
+		w.Write([]byte(strings.Repeat("large body ", 10)))
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "image/png")
+		w.Write([]byte{0x89, 'P', 'N', 'G', 0xff, 0xfe})
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestThatRecorderWritesReplayableRoutes(t *testing.T) {
+	backend := recordedBackend(t)
+	dir := t.TempDir()
+
+	recorder, err := app.NewRecorder(app.RecordConfig{
+		Target:      backend.URL,
+		Dir:         dir,
+		MaxBodySize: 50,
+		Redact: []app.RedactRule{
+			{Field: "user.password"},
+			{Pattern: "secret-[a-z]+", Replacement: "***"},
+		},
+	}, testLogger)
+	assert.NoError(t, err)
+
+	requests := []string{"/users?page=1", "/users?page=2", "/users?page=2", "/large", "/image"}
+	live := map[string]string{}
+	for _, target := range requests {
+		w := httptest.NewRecorder()
+		recorder.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		live[target] = w.Body.String()
+	}
+
+	// The client gets the real response, secrets and all
+	assert.Contains(t, live["/users?page=1"], "secret-abc")
+
+	entries, _ := os.ReadDir(dir)
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	assert.Len(t, names, 5)
+	assert.Contains(t, names, "bodies")
+	assert.Contains(t, names, "get-large.json")
+	assert.Contains(t, names, "get-image.json")
+
+	data, _ := os.ReadFile(filepath.Join(dir, "get-large.json"))
+	assert.Contains(t, string(data), `"body": "file:`+filepath.Join(dir, "bodies", "get-large.txt")+`"`)
+
+	t.Setenv("GOMSVC_ROUTES_DIR", dir)
+	routes, err := app.LoadRoutesFromDir()
+	assert.NoError(t, err)
+	assert.Len(t, routes, 4)
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(app.Config{Routes: routes}, router, testLogger)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?page=2", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"page": "2", "token": "***", "user": {"name": "john", "password": "REDACTED"}}`, w.Body.String())
+	assert.Equal(t, "token ***", w.Header().Get("X-Custom"))
+	assert.Equal(t, "REDACTED", w.Header().Get("Set-Cookie"))
+	assert.Empty(t, w.Header().Get("X-Request-Id"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?page=1", nil))
+	assert.Contains(t, w.Body.String(), `"page": "1"`)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/large", nil))
+	assert.Equal(t, live["/large"], w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/image", nil))
+	assert.Equal(t, live["/image"], w.Body.String())
+	assert.Equal(t, "image/png", w.Header().Get("Content-Type"))
+}
+
+func TestThatRecorderGroupsRequestsByMatchedHeaders(t *testing.T) {
+	backend := recordedBackend(t)
+	dir := t.TempDir()
+
+	recorder, err := app.NewRecorder(app.RecordConfig{
+		Target:       backend.URL,
+		Dir:          dir,
+		MatchQuery:   []string{},
+		MatchHeaders: []string{"X-Tenant"},
+	}, testLogger)
+	assert.NoError(t, err)
+
+	for _, tenant := range []string{"a", "b", "a"} {
+		r := httptest.NewRequest(http.MethodGet, "/users?page="+tenant, nil)
+		r.Header.Set("X-Tenant", tenant)
+		recorder.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 2)
+
+	for _, entry := range entries {
+		data, _ := os.ReadFile(filepath.Join(dir, entry.Name()))
+		var route map[string]interface{}
+		assert.NoError(t, json.Unmarshal(data, &route))
+		match := route["match"].(map[string]interface{})
+		assert.Contains(t, match, "headers")
+		assert.NotContains(t, match, "query")
+	}
+}
+
+func TestThatRecorderForwardsRequestBodies(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer backend.Close()
+
+	recorder, err := app.NewRecorder(app.RecordConfig{Target: backend.URL, Dir: t.TempDir()}, testLogger)
+	assert.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	recorder.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/echo", strings.NewReader("hello")))
+	assert.Equal(t, "hello", w.Body.String())
+}
+
+func TestThatRecorderKeepsPathsWithTheSameNameApart(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("path " + r.URL.Path))
+	}))
+	defer backend.Close()
+	dir := t.TempDir()
+
+	recorder, err := app.NewRecorder(app.RecordConfig{Target: backend.URL, Dir: dir}, testLogger)
+	assert.NoError(t, err)
+
+	for _, target := range []string{"/a/b", "/a-b", "/A/b"} {
+		recorder.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	entries, _ := os.ReadDir(dir)
+	assert.Len(t, entries, 3)
+
+	t.Setenv("GOMSVC_ROUTES_DIR", dir)
+	routes, err := app.LoadRoutesFromDir()
+	assert.NoError(t, err)
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(app.Config{Routes: routes}, router, testLogger)
+
+	for _, target := range []string{"/a/b", "/a-b", "/A/b"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		assert.Equal(t, "path "+target, w.Body.String())
+	}
+
+	// Anything else in the routes directory is still expected to be a route
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a route"), 0o644)
+	_, err = app.LoadRoutesFromDir()
+	assert.Error(t, err)
+}
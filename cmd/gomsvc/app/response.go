@@ -5,38 +5,145 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
 )
 
 type Response struct {
-	Headers                   map[string]string `json:"headers"`
-	StatusCode                int               `json:"status_code"`
-	Body                      interface{}       `json:"body"`
-	IncludeUpstreamResponses  bool              `json:"concat_upstream_responses"`
-	IncludeRequestInformation bool              `json:"include_request_information"`
+	Headers                   map[string]string      `json:"headers"`
+	StatusCode                int                    `json:"status_code"`
+	Body                      interface{}            `json:"body"`
+	Bodies                    map[string]interface{} `json:"bodies"`
+	IncludeUpstreamResponses  bool                   `json:"concat_upstream_responses"`
+	IncludeRequestInformation bool                   `json:"include_request_information"`
+	XMLRoot                   string                 `json:"xml_root"`
+	Compression               *Compression           `json:"compression"`
+	Throttle                  *Throttle              `json:"throttle"`
+	ETag                      string                 `json:"etag"`
+	LastModified              string                 `json:"last_modified"`
+	CacheControl              string                 `json:"cache_control"`
+	Cookies                   []Cookie               `json:"cookies"`
+	Session                   *SessionUpdate         `json:"session"`
+	Template                  bool                   `json:"template"`
+	DisableRanges             bool                   `json:"disable_ranges"`
+	Pagination                *Pagination            `json:"pagination"`
+	Mappings                  []Mapping              `json:"mappings"`
+	Passthrough               *Passthrough           `json:"passthrough"`
 }
 
 func (r Response) Content(request *http.Request, upstreamResponses []*http.Response) ([]byte, error) {
+	return r.content(request, newTemplateData(request, true), resultsFromResponses(upstreamResponses))
+}
+
+// content renders the response with the results of calling its upstreams,
+// including upstreams that could not be reached.
+func (r Response) content(request *http.Request, data templateData, results []upstreamResult) ([]byte, error) {
 
 	if request == nil {
 		return nil, errors.New("request was nil")
 	}
 
-	contentType, ok := r.Headers["content-type"]
+	if r.Template {
+		body, err := r.renderedBody(data)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	if len(r.Mappings) > 0 {
+		body, err := r.mappedBody(request, results)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = body
+	}
+
+	headers := r.header()
+
+	if httptools.IsJSON(headers) {
+		return r.json(request, results)
+	}
+
+	if httptools.IsXML(headers) {
+		return r.xml(request, results)
+	}
+
+	return r.text(request, results)
+}
+
+// render returns the content to serve, a precompressed body is served
+// exactly as it is stored since it can not be inspected or extended.
+func (r Response) render(request *http.Request, data templateData, results []upstreamResult) ([]byte, error) {
+	if r.Compression != nil && r.Compression.Precompressed != "" {
+		return r.bodyData()
+	}
+	return r.content(request, data, results)
+}
+
+// renderedBody returns the body rendered with data, file: bodies are read
+// before being rendered.
+func (r Response) renderedBody(data templateData) (interface{}, error) {
+	if _, ok := r.Body.(string); ok {
+		body, err := r.bodyData()
+		if err != nil {
+			return nil, err
+		}
+		return renderTemplate(string(body), data)
+	}
+
+	return renderTemplateValues(r.Body, data)
+}
+
+// Negotiate picks the body from Bodies that best matches the Accept header
+// of the request and returns a copy of the response using that body and its
+// media type as content type. The returned bool is false when none of the
+// bodies are acceptable. Responses without Bodies are returned as is.
+func (r Response) Negotiate(request *http.Request) (Response, bool) {
+	if len(r.Bodies) == 0 {
+		return r, true
+	}
+
+	preferred := r.header().Get("content-type")
+	offers := make([]string, 0, len(r.Bodies))
+	for mediaType := range r.Bodies {
+		offers = append(offers, mediaType)
+	}
+
+	// Offers are sorted to make ties predictable, the configured content
+	// type is preferred whenever it is one of the offers
+	sort.Slice(offers, func(i, j int) bool {
+		if (offers[i] == preferred) != (offers[j] == preferred) {
+			return offers[i] == preferred
+		}
+		return offers[i] < offers[j]
+	})
+
+	mediaType, ok := httptools.NegotiateContentType(request.Header.Get("Accept"), offers)
 
-	if ok && contentType == "application/json" {
-		return r.json(request, upstreamResponses)
+	if !ok {
+		return r, false
 	}
 
-	return r.text(request, upstreamResponses)
+	headers := map[string]string{}
+	for k, v := range r.Headers {
+		if !strings.EqualFold(k, "content-type") {
+			headers[k] = v
+		}
+	}
+	headers["content-type"] = mediaType
+
+	r.Headers = headers
+	r.Body = r.Bodies[mediaType]
+
+	return r, true
 }
 
-func (r Response) text(request *http.Request, upstreamResponses []*http.Response) ([]byte, error) {
+func (r Response) text(request *http.Request, results []upstreamResult) ([]byte, error) {
 	var buf bytes.Buffer
 
 	if r.shouldIncludeRequestInformation(request) {
@@ -56,31 +163,39 @@ func (r Response) text(request *http.Request, upstreamResponses []*http.Response
 		buf.WriteString("\n")
 	}
 
-	body := r.Body.(string)
+	body, err := r.bodyData()
 
-	if strings.HasPrefix(body, "file:") { // TODO: DRY this
-		data, err := os.ReadFile(strings.TrimPrefix(body, "file:"))
-		if err != nil {
-			return nil, err
-		}
-		body = string(data)
+	if err != nil {
+		return nil, err
 	}
 
-	buf.WriteString(body)
+	buf.Write(body)
 
-	if r.includeUpstreamResponses(request, upstreamResponses) {
+	if r.includeUpstreamResponses(request, results) {
 		buf.WriteString("\n\n#####################\n")
 		buf.WriteString("#   Upstream calls  #\n")
 		buf.WriteString("#####################\n")
 
-		for _, upstreamResponse := range upstreamResponses {
-			if upstreamResponse == nil {
+		for _, result := range results {
+			if result.skipped {
 				continue
 			}
-			upstreamData, err := io.ReadAll(upstreamResponse.Body)
+			if result.err != nil {
+				buf.WriteString(fmt.Sprintf(
+					"\n\t%s - %s - %s\n",
+					result.upstream.method(),
+					result.url(),
+					result.redact(result.err.Error()),
+				))
+				continue
+			}
+
+			upstreamResponse := result.response
+			upstreamData, err := upstreamBody(upstreamResponse)
 			if err != nil {
 				return nil, err
 			}
+			upstreamData = []byte(result.redact(string(upstreamData)))
 
 			if httptools.IsJSON(upstreamResponse.Header) {
 				upstreamData, err = httptools.FormatJSONData(upstreamData)
@@ -98,46 +213,163 @@ func (r Response) text(request *http.Request, upstreamResponses []*http.Response
 				bytes.ReplaceAll(upstreamData, []byte{'\n'}, []byte{'\n', '\t'}), // Keeps everything indented
 			))
 		}
+
+		for _, result := range results {
+			if result.upstream.Retry != nil {
+				buf.WriteString(fmt.Sprintf("\n\t%s took %d attempts", result.url(), len(result.attempts)))
+			}
+		}
 	}
 
 	return buf.Bytes(), nil
 }
 
-func (r Response) json(request *http.Request, upstreamResponses []*http.Response) ([]byte, error) {
+func (r Response) json(request *http.Request, results []upstreamResult) ([]byte, error) {
+
+	body, err := r.copyBody()
 
-	body := r.copyBody()
+	if err != nil {
+		return nil, err
+	}
+
+	elements := map[string]interface{}{}
 
 	if r.shouldIncludeRequestInformation(request) {
-		body["request"] = map[string]interface{}{
-			"client_ip": httptools.ClientIP(request),
-			"method":    request.Method,
-			"headers":   request.Header,
-		}
+		elements["request"] = requestInformation(request)
 	}
 
-	if r.includeUpstreamResponses(request, upstreamResponses) {
+	if r.includeUpstreamResponses(request, results) {
 		upstreamContents := []interface{}{}
-		for _, upstreamResponse := range upstreamResponses {
-			upstreamData, _ := io.ReadAll(upstreamResponse.Body)
+		for _, result := range results {
+			if result.err != nil || result.skipped {
+				upstreamContents = append(upstreamContents, result.information(nil))
+				continue
+			}
+			upstreamResponse := result.response
+			upstreamData, _ := upstreamBody(upstreamResponse)
+			upstreamData = []byte(result.redact(string(upstreamData)))
 			if httptools.IsJSON(upstreamResponse.Header) {
-				container := map[string]interface{}{}
-				if err := json.Unmarshal(upstreamData, &container); err != nil {
+				var container interface{}
+				if err := json.Unmarshal(upstreamData, &container); err == nil {
+					upstreamContents = append(upstreamContents, result.information(container))
 					continue
 				}
-				upstreamContents = append(upstreamContents, map[string]interface{}{
-					"url":         upstreamResponse.Request.URL.String(),
-					"headers":     upstreamResponse.Header,
-					"status_code": upstreamResponse.StatusCode,
-					"body":        container,
-				})
+			}
+			// Plain bodies are kept as they are unless there are attempts
+			// that need an object to be recorded in
+			if result.upstream.Retry != nil {
+				upstreamContents = append(upstreamContents, result.information(string(upstreamData)))
 				continue
 			}
 			upstreamContents = append(upstreamContents, string(upstreamData))
 		}
-		body["upstreams"] = upstreamContents
+		elements["upstreams"] = upstreamContents
+	}
+
+	return httptools.FormatJSON(withElements(body, elements))
+}
+
+func (r Response) xml(request *http.Request, results []upstreamResult) ([]byte, error) {
+
+	elements := map[string]interface{}{}
+
+	if r.shouldIncludeRequestInformation(request) {
+		elements["request"] = requestInformation(request)
+	}
+
+	if r.includeUpstreamResponses(request, results) {
+		upstreamContents := []interface{}{}
+		for _, result := range results {
+			if result.err != nil || result.skipped {
+				upstreamContents = append(upstreamContents, result.xmlInformation(nil))
+				continue
+			}
+			upstreamResponse := result.response
+			upstreamData, err := upstreamBody(upstreamResponse)
+			if err != nil {
+				return nil, err
+			}
+			upstreamData = []byte(result.redact(string(upstreamData)))
+			var upstreamBody interface{} = string(upstreamData)
+			if httptools.IsXML(upstreamResponse.Header) {
+				upstreamBody = httptools.RawXML(upstreamData)
+			}
+			if httptools.IsJSON(upstreamResponse.Header) {
+				var container interface{}
+				if err := json.Unmarshal(upstreamData, &container); err == nil {
+					upstreamBody = container
+				}
+			}
+			upstreamContents = append(upstreamContents, result.xmlInformation(upstreamBody))
+		}
+		elements["upstreams"] = map[string]interface{}{"upstream": upstreamContents}
+	}
+
+	// String bodies are treated as complete XML documents and any extra
+	// elements are appended to their root element
+	if _, ok := r.Body.(string); ok {
+		data, err := r.bodyData()
+		if err != nil || len(elements) == 0 {
+			return data, err
+		}
+		return httptools.AppendXML(data, elements)
+	}
+
+	body, err := r.copyBody()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Body == nil {
+		body = map[string]interface{}{}
+	}
+
+	return httptools.FormatXML(r.xmlRoot(), withElements(body, elements))
+}
+
+func (r Response) xmlRoot() string {
+	if r.XMLRoot == "" {
+		return defaultXMLRoot
+	}
+	return r.XMLRoot
+}
+
+// header returns the configured response headers as a http.Header so
+// lookups are not sensitive to the casing used in the configuration.
+func (r Response) header() http.Header {
+	headers := http.Header{}
+	for k, v := range r.Headers {
+		headers.Set(k, v)
+	}
+	return headers
+}
+
+// bodyData returns the body as bytes, reading it from disk when it is
+// prefixed with file: and encoding it as JSON when it is not a string.
+func (r Response) bodyData() ([]byte, error) {
+	s, ok := r.Body.(string)
+
+	if !ok {
+		if r.Body == nil {
+			return []byte{}, nil
+		}
+		return json.Marshal(r.Body)
 	}
 
-	return httptools.FormatJSON(body)
+	if strings.HasPrefix(s, "file:") {
+		return os.ReadFile(strings.TrimPrefix(s, "file:"))
+	}
+
+	return []byte(s), nil
+}
+
+func requestInformation(request *http.Request) map[string]interface{} {
+	return map[string]interface{}{
+		"client_ip": httptools.ClientIP(request),
+		"method":    request.Method,
+		"headers":   request.Header,
+	}
 }
 
 func (r Response) shouldIncludeRequestInformation(request *http.Request) bool {
@@ -147,26 +379,55 @@ func (r Response) shouldIncludeRequestInformation(request *http.Request) bool {
 	return false
 }
 
-func (r Response) includeUpstreamResponses(req *http.Request, upstreamResponses []*http.Response) bool {
-	return len(upstreamResponses) > 0 && (r.IncludeUpstreamResponses || req.Header.Get(httpHeaderAddUpstreamsInResponse) != "")
+func (r Response) includeUpstreamResponses(req *http.Request, results []upstreamResult) bool {
+	return len(results) > 0 && (r.IncludeUpstreamResponses || req.Header.Get(httpHeaderAddUpstreamsInResponse) != "")
 }
 
-func (r Response) copyBody() map[string]interface{} {
+// copyBody returns the body as a value that is safe to extend, string bodies
+// are decoded as JSON documents and objects are copied. Any valid JSON value
+// is allowed, including arrays, strings, numbers and null.
+func (r Response) copyBody() (interface{}, error) {
 
-	body := r.Body
-	container := map[string]interface{}{}
+	if _, ok := r.Body.(string); ok {
+		data, err := r.bodyData()
+		if err != nil {
+			return nil, err
+		}
+		// Strings that are not JSON have always been served as an empty
+		// object, which routes with plain text bodies rely on
+		var container interface{}
+		if err := json.Unmarshal(data, &container); err != nil {
+			return map[string]interface{}{}, nil
+		}
+		return container, nil
+	}
 
-	if s, ok := body.(string); ok {
-		if strings.HasPrefix(s, "file:") { // TODO: DRY this
-			data, _ := os.ReadFile(strings.TrimPrefix(s, "file:"))
-			json.Unmarshal(data, &container)
-			return container
+	if body, ok := r.Body.(map[string]interface{}); ok {
+		container := make(map[string]interface{}, len(body))
+		for k, v := range body {
+			container[k] = v
 		}
-		json.Unmarshal([]byte(s), &container)
-		return container
+		return container, nil
+	}
+
+	return r.Body, nil
+}
+
+// withElements adds elements such as request information to body. Bodies
+// that are not objects can not hold them, so they are wrapped in an object
+// with the original body under the body key next to the elements.
+func withElements(body interface{}, elements map[string]interface{}) interface{} {
+	if len(elements) == 0 {
+		return body
+	}
+
+	container, ok := body.(map[string]interface{})
+
+	if !ok {
+		container = map[string]interface{}{wrappedBodyKey: body}
 	}
 
-	for k, v := range r.Body.(map[string]interface{}) {
+	for k, v := range elements {
 		container[k] = v
 	}
 
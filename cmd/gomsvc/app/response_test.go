@@ -89,3 +89,176 @@ func TestJSONWithUpstream(t *testing.T) {
 
 	assert.Equal(t, expectedJSON, string(x))
 }
+
+func TestXMLWithUpstreamAndRequestInformation(t *testing.T) {
+	url, _ := url.Parse("http://example.com")
+	upstreamResponse := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader(`<?xml version="1.0"?><city>New York</city>`)),
+		Request: &http.Request{
+			URL: url,
+		},
+	}
+	upstreamResponse.Header.Set("content-type", "text/xml")
+	response := app.Response{
+		Headers:                   map[string]string{"content-type": "application/xml"},
+		Body:                      map[string]interface{}{"@version": "2", "name": "John"},
+		XMLRoot:                   "person",
+		IncludeUpstreamResponses:  true,
+		IncludeRequestInformation: true,
+	}
+
+	expectedXML := `<?xml version="1.0" encoding="UTF-8"?>
+<person version="2">
+ <name>John</name>
+ <request>
+  <client_ip>192.0.2.1</client_ip>
+  <headers>
+   <X-Foo>bar</X-Foo>
+  </headers>
+  <method>GET</method>
+ </request>
+ <upstreams>
+  <upstream>
+   <body>
+    <city>New York</city>
+   </body>
+   <headers>
+    <Content-Type>text/xml</Content-Type>
+   </headers>
+   <status_code>200</status_code>
+   <url>http://example.com</url>
+  </upstream>
+ </upstreams>
+</person>`
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.RemoteAddr = "192.0.2.1"
+	req.Header.Set("X-Foo", "bar")
+	xmlBytes, err := response.Content(req, []*http.Response{upstreamResponse})
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedXML, string(xmlBytes))
+}
+
+func TestXMLWithStringBody(t *testing.T) {
+	response := app.Response{
+		Headers:                   map[string]string{"Content-Type": "text/xml; charset=utf-8"},
+		Body:                      `<note><to>Tove</to></note>`,
+		IncludeRequestInformation: true,
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	req.RemoteAddr = "192.0.2.1"
+	xmlBytes, err := response.Content(req, nil)
+
+	assert.NoError(t, err)
+	assert.Contains(t, string(xmlBytes), "<to>Tove</to>\n <request>\n  <client_ip>192.0.2.1</client_ip>")
+	assert.True(t, strings.HasSuffix(string(xmlBytes), "</request>\n</note>"))
+}
+
+func TestTextWithNonStringBody(t *testing.T) {
+	response := app.Response{
+		Body: map[string]interface{}{"foo": "bar"},
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	data, err := response.Content(req, nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"foo":"bar"}`, string(data))
+}
+
+func TestJSONWithArrayAndScalarBodies(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     interface{}
+		expected string
+	}{
+		{name: "array", body: []interface{}{map[string]interface{}{"id": 1}}, expected: "[\n {\n  \"id\": 1\n }\n]"},
+		{name: "array string", body: `[{"id":1}]`, expected: "[\n {\n  \"id\": 1\n }\n]"},
+		{name: "string", body: `"hello"`, expected: `"hello"`},
+		{name: "number", body: 42, expected: "42"},
+		{name: "null", body: nil, expected: "null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := app.Response{
+				Headers: map[string]string{"content-type": "application/json"},
+				Body:    tt.body,
+			}
+			req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+			data, err := response.Content(req, nil)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, string(data))
+		})
+	}
+}
+
+func TestJSONWrapsNonObjectBodyWhenInjectingInformation(t *testing.T) {
+	url, _ := url.Parse("http://example.com")
+	upstreamResponse := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"application/json; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(`[1, 2]`)),
+		Request:    &http.Request{URL: url},
+	}
+	response := app.Response{
+		Headers:                  map[string]string{"content-type": "application/json"},
+		Body:                     []interface{}{"a", "b"},
+		IncludeUpstreamResponses: true,
+	}
+
+	expectedJSON := `{
+ "body": [
+  "a",
+  "b"
+ ],
+ "upstreams": [
+  {
+   "body": [
+    1,
+    2
+   ],
+   "headers": {
+    "Content-Type": [
+     "application/json; charset=utf-8"
+    ]
+   },
+   "status_code": 200,
+   "url": "http://example.com"
+  }
+ ]
+}`
+
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	data, err := response.Content(req, []*http.Response{upstreamResponse})
+
+	assert.NoError(t, err)
+	assert.Equal(t, expectedJSON, string(data))
+}
+
+func TestJSONWithInvalidStringBody(t *testing.T) {
+	for _, body := range []string{"{not json", "plain text", ""} {
+		response := app.Response{
+			Headers:                   map[string]string{"content-type": "application/json"},
+			Body:                      body,
+			IncludeRequestInformation: body == "",
+		}
+		req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+
+		data, err := response.Content(req, nil)
+
+		assert.NoError(t, err)
+		if body == "" {
+			assert.Contains(t, string(data), `"request": {`)
+			continue
+		}
+		assert.Equal(t, "{}", string(data))
+	}
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	RetryErrorTimeout    = "timeout"
+	RetryErrorConnection = "connection"
+
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMultiplier = 2
+)
+
+var defaultRetryStatuses = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Retry decides when a failed upstream call is attempted again and how
+// long to wait before doing so. Errors lists which kinds of transport errors
+// are retried, all of them are when it is left out.
+type Retry struct {
+	MaxAttempts       int      `json:"max_attempts"`
+	Statuses          []int    `json:"statuses"`
+	Errors            []string `json:"errors"`
+	Backoff           Duration `json:"backoff"`
+	MaxBackoff        Duration `json:"max_backoff"`
+	Multiplier        float64  `json:"multiplier"`
+	Jitter            float64  `json:"jitter"`
+	RespectRetryAfter bool     `json:"respect_retry_after"`
+}
+
+// upstreamAttempt is a single call to an upstream, delay is how long was
+// waited before the next attempt.
+type upstreamAttempt struct {
+	statusCode int
+	err        error
+	duration   time.Duration
+	delay      time.Duration
+}
+
+func (r *Retry) maxAttempts() int {
+	if r == nil {
+		return 1
+	}
+	if r.MaxAttempts <= 0 {
+		return defaultRetryAttempts
+	}
+	return r.MaxAttempts
+}
+
+// retryable tells if the outcome of an attempt is worth another attempt.
+func (r *Retry) retryable(response *http.Response, err error) bool {
+	if r == nil {
+		return false
+	}
+
+	if err != nil {
+		if r.Errors == nil {
+			return true
+		}
+		kind := RetryErrorConnection
+		if isTimeout(err) {
+			kind = RetryErrorTimeout
+		}
+		for _, retryable := range r.Errors {
+			if retryable == kind {
+				return true
+			}
+		}
+		return false
+	}
+
+	statuses := r.Statuses
+	if statuses == nil {
+		statuses = defaultRetryStatuses
+	}
+
+	for _, status := range statuses {
+		if status == response.StatusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// delay returns how long to wait after the given attempt, growing
+// exponentially and reduced by a random part of up to jitter. A Retry-After
+// header in the response is used instead when it is respected.
+func (r *Retry) delay(attempt int, response *http.Response) time.Duration {
+	if r.RespectRetryAfter && response != nil {
+		if delay, ok := retryAfter(response.Header.Get("Retry-After")); ok {
+			if r.MaxBackoff.Duration > 0 && delay > r.MaxBackoff.Duration {
+				delay = r.MaxBackoff.Duration
+			}
+			return delay
+		}
+	}
+
+	backoff := r.Backoff.Duration
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	multiplier := r.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultRetryMultiplier
+	}
+
+	delay := time.Duration(float64(backoff) * math.Pow(multiplier, float64(attempt-1)))
+
+	if r.MaxBackoff.Duration > 0 && delay > r.MaxBackoff.Duration {
+		delay = r.MaxBackoff.Duration
+	}
+
+	if r.Jitter > 0 {
+		jitter := math.Min(r.Jitter, 1)
+		delay -= time.Duration(float64(delay) * jitter * rand.Float64())
+	}
+
+	return delay
+}
+
+// retryAfter parses a Retry-After header, which is either a number of
+// seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+
+	return 0, false
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// information describes the attempt for the upstream section of responses.
+func (a upstreamAttempt) information(number int) map[string]interface{} {
+	information := map[string]interface{}{
+		"number":      number,
+		"duration_ms": a.duration.Milliseconds(),
+	}
+	if a.err != nil {
+		information["error"] = a.err.Error()
+	} else {
+		information["status_code"] = a.statusCode
+	}
+	if a.delay > 0 {
+		information["delay_ms"] = a.delay.Milliseconds()
+	}
+	return information
+}
+
+// describe describes the attempt for the logs.
+func (a upstreamAttempt) describe() string {
+	outcome := "got status " + strconv.Itoa(a.statusCode)
+	if a.err != nil {
+		outcome = "failed with " + a.err.Error()
+	}
+	outcome += " after " + a.duration.String()
+	if a.delay > 0 {
+		outcome += ", retrying in " + a.delay.String()
+	}
+	return outcome
+}
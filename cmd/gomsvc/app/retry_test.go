@@ -0,0 +1,185 @@
+package app_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer answers with the given statuses in order and then with 200.
+func flakyServer(t *testing.T, headers http.Header, statuses ...int) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(&calls, 1))
+		w.Header().Set("content-type", "application/json")
+		if n <= len(statuses) {
+			for k, v := range headers {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(statuses[n-1])
+		}
+		w.Write([]byte(`{"call": ` + strconv.Itoa(n) + `}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+type attemptInformation struct {
+	Number     int    `json:"number"`
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error"`
+	DelayMS    int64  `json:"delay_ms"`
+}
+
+func retriedAttempts(t *testing.T, data []byte) []attemptInformation {
+	var body struct {
+		Upstreams []struct {
+			Attempts []attemptInformation `json:"attempts"`
+		} `json:"upstreams"`
+	}
+	assert.NoError(t, json.Unmarshal(data, &body))
+	if len(body.Upstreams) == 0 {
+		return nil
+	}
+	return body.Upstreams[0].Attempts
+}
+
+func retryRoute(upstream app.Upstream) app.Route {
+	return app.Route{
+		Name:      "retry",
+		Path:      "/",
+		Method:    http.MethodGet,
+		Upstreams: []app.Upstream{upstream},
+		Response: app.Response{
+			StatusCode:               http.StatusOK,
+			Headers:                  map[string]string{"content-type": "application/json"},
+			IncludeUpstreamResponses: true,
+			Passthrough:              &app.Passthrough{Status: app.PassthroughStatusWorst},
+		},
+	}
+}
+
+func TestThatUpstreamsAreRetried(t *testing.T) {
+	server, calls := flakyServer(t, nil, http.StatusServiceUnavailable, http.StatusBadGateway)
+	log := &recordingLogger{}
+	upstream := app.Upstream{
+		URL:    server.URL,
+		Method: http.MethodGet,
+		Retry:  &app.Retry{MaxAttempts: 3, Backoff: app.Duration{Duration: 10 * time.Millisecond}},
+	}
+
+	w := httptest.NewRecorder()
+	started := time.Now()
+	app.MakeHandlerFunc(retryRoute(upstream), app.Config{}, log)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.GreaterOrEqual(t, time.Since(started), 30*time.Millisecond)
+	assert.Equal(t, int32(3), *calls)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []attemptInformation{
+		{Number: 1, StatusCode: http.StatusServiceUnavailable, DelayMS: 10},
+		{Number: 2, StatusCode: http.StatusBadGateway, DelayMS: 20},
+		{Number: 3, StatusCode: http.StatusOK},
+	}, retriedAttempts(t, w.Body.Bytes()))
+	assert.True(t, log.contains("attempt 1 got status 503"))
+	assert.True(t, log.contains("retrying in 20ms"))
+	assert.True(t, log.contains("attempt 3 got status 200"))
+}
+
+func TestThatUpstreamRetriesGiveUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		retry    app.Retry
+		calls    int32
+		status   int
+	}{
+		{name: "max attempts", statuses: []int{503, 503, 503}, retry: app.Retry{MaxAttempts: 2}, calls: 2, status: http.StatusServiceUnavailable},
+		{name: "status not retryable by default", statuses: []int{500}, retry: app.Retry{}, calls: 1, status: http.StatusInternalServerError},
+		{name: "configured statuses", statuses: []int{500, 503}, retry: app.Retry{Statuses: []int{500}}, calls: 2, status: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, calls := flakyServer(t, nil, tt.statuses...)
+			retry := tt.retry
+			retry.Backoff = app.Duration{Duration: time.Millisecond}
+			upstream := app.Upstream{URL: server.URL, Method: http.MethodGet, Retry: &retry}
+
+			w := httptest.NewRecorder()
+			app.MakeHandlerFunc(retryRoute(upstream), app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			assert.Equal(t, tt.calls, *calls)
+			assert.Equal(t, tt.status, w.Code)
+			assert.Len(t, retriedAttempts(t, w.Body.Bytes()), int(tt.calls))
+		})
+	}
+}
+
+func TestThatUpstreamRetriesTransportErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		errors   []string
+		attempts int
+	}{
+		{name: "all errors by default", errors: nil, attempts: 3},
+		{name: "only timeouts", errors: []string{app.RetryErrorTimeout}, attempts: 1},
+		{name: "connection errors", errors: []string{app.RetryErrorConnection}, attempts: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := app.Upstream{
+				URL:    "http://127.0.0.1:1",
+				Method: http.MethodGet,
+				Retry:  &app.Retry{Errors: tt.errors, Backoff: app.Duration{Duration: time.Millisecond}},
+			}
+
+			w := httptest.NewRecorder()
+			app.MakeHandlerFunc(retryRoute(upstream), app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			attempts := retriedAttempts(t, w.Body.Bytes())
+			assert.Equal(t, http.StatusBadGateway, w.Code)
+			assert.Len(t, attempts, tt.attempts)
+			assert.NotEmpty(t, attempts[0].Error)
+		})
+	}
+}
+
+func TestThatUpstreamRetriesRespectRetryAfter(t *testing.T) {
+	server, calls := flakyServer(t, http.Header{"Retry-After": {"1"}}, http.StatusTooManyRequests)
+	upstream := app.Upstream{
+		URL:    server.URL,
+		Method: http.MethodGet,
+		Retry:  &app.Retry{Backoff: app.Duration{Duration: time.Millisecond}, RespectRetryAfter: true},
+	}
+
+	w := httptest.NewRecorder()
+	started := time.Now()
+	app.MakeHandlerFunc(retryRoute(upstream), app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.GreaterOrEqual(t, time.Since(started), time.Second)
+	assert.Equal(t, int32(2), *calls)
+	assert.Equal(t, int64(1000), retriedAttempts(t, w.Body.Bytes())[0].DelayMS)
+}
+
+func TestThatRetryAfterIsLimitedByMaxBackoff(t *testing.T) {
+	server, calls := flakyServer(t, http.Header{"Retry-After": {"3600"}}, http.StatusTooManyRequests)
+	upstream := app.Upstream{
+		URL:    server.URL,
+		Method: http.MethodGet,
+		Retry:  &app.Retry{MaxBackoff: app.Duration{Duration: 20 * time.Millisecond}, RespectRetryAfter: true},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(retryRoute(upstream), app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, int32(2), *calls)
+	assert.Equal(t, int64(20), retriedAttempts(t, w.Body.Bytes())[0].DelayMS)
+}
@@ -1,9 +1,42 @@
 package app
 
+import "net/http"
+
+const (
+	RouteKindSSE       = "sse"
+	RouteKindWebSocket = "websocket"
+	RouteKindProxy     = "proxy"
+
+	// AnyMethod lets a route handle requests regardless of their method
+	AnyMethod = "*"
+)
+
+// anyMethods are the methods registered for routes using AnyMethod.
+var anyMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
 type Route struct {
-	Name      string     `json:"name"`
-	Path      string     `json:"path"`
-	Method    string     `json:"method"`
-	Upstreams []Upstream `json:"upstreams"`
-	Response  Response   `json:"response"`
+	Name      string          `json:"name"`
+	Path      string          `json:"path"`
+	Method    string          `json:"method"`
+	Match     *RequestMatcher `json:"match"`
+	Kind      string          `json:"kind"`
+	Upstreams []Upstream      `json:"upstreams"`
+	Fanout    Fanout          `json:"fanout"`
+	Response  Response        `json:"response"`
+	SSE       *SSE            `json:"sse"`
+	WebSocket *WebSocket      `json:"websocket"`
+	CORS      *CORS           `json:"cors"`
+	Proxy     *Proxy          `json:"proxy"`
+}
+
+// handles tells if the route handles requests with method.
+func (r Route) handles(method string) bool {
+	return r.Method == method || r.Method == AnyMethod
 }
@@ -0,0 +1,199 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSessionCookieName = "gomsvc_session"
+
+	// sessionEvictInterval is how often expired sessions that were not
+	// used again are removed
+	sessionEvictInterval = time.Minute
+)
+
+type sessionContextKey struct{}
+
+// sessions holds the server side state of all sessions, it is shared by
+// every route so one route can read what another has stored.
+var sessions = &sessionStore{sessions: map[string]*session{}}
+
+type SessionConfig struct {
+	CookieName string   `json:"cookie_name"`
+	TTL        Duration `json:"ttl"`
+	Secure     bool     `json:"secure"`
+	SameSite   string   `json:"same_site"`
+}
+
+type SessionUpdate struct {
+	Set     map[string]interface{} `json:"set"`
+	Delete  []string               `json:"delete"`
+	Destroy bool                   `json:"destroy"`
+}
+
+type session struct {
+	values  map[string]interface{}
+	expires time.Time
+}
+
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session
+	evicted  time.Time
+}
+
+func (s *session) expired(now time.Time) bool {
+	return !s.expires.IsZero() && now.After(s.expires)
+}
+
+// Values returns a copy of the values of the session with id, nil when
+// there is no such session or it has expired.
+func (s *sessionStore) Values(id string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	current, ok := s.sessions[id]
+
+	if !ok {
+		return nil
+	}
+
+	if current.expired(time.Now()) {
+		delete(s.sessions, id)
+		return nil
+	}
+
+	values := make(map[string]interface{}, len(current.values))
+	for k, v := range current.values {
+		values[k] = v
+	}
+
+	return values
+}
+
+// Update applies update to the session with id, creating it if needed.
+func (s *sessionStore) Update(id string, values map[string]interface{}, remove []string, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.evict(time.Now())
+
+	current, ok := s.sessions[id]
+
+	if !ok {
+		current = &session{values: map[string]interface{}{}}
+		s.sessions[id] = current
+	}
+
+	for k, v := range values {
+		current.values[k] = v
+	}
+
+	for _, k := range remove {
+		delete(current.values, k)
+	}
+
+	if ttl > 0 {
+		current.expires = time.Now().Add(ttl)
+	}
+}
+
+// evict removes the sessions that have expired, at most once every
+// sessionEvictInterval. Sessions are only added by updates, so evicting
+// there keeps the store from growing with sessions nobody comes back to.
+func (s *sessionStore) evict(now time.Time) {
+	if now.Sub(s.evicted) < sessionEvictInterval {
+		return
+	}
+
+	s.evicted = now
+
+	for id, current := range s.sessions {
+		if current.expired(now) {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+func (s *sessionStore) Destroy(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, id)
+}
+
+func (c *SessionConfig) cookieName() string {
+	if c == nil || c.CookieName == "" {
+		return defaultSessionCookieName
+	}
+	return c.CookieName
+}
+
+// withSession stores the id of the session the request belongs to in its
+// context, so templates and matchers can find it. The id is empty when the
+// request does not belong to a session, the session is only looked up once
+// for a request however many handlers it passes through.
+func withSession(r *http.Request, config *SessionConfig) *http.Request {
+	if _, ok := r.Context().Value(sessionContextKey{}).(string); ok {
+		return r
+	}
+	id := ""
+	if cookie, err := r.Cookie(config.cookieName()); err == nil && sessions.Values(cookie.Value) != nil {
+		id = cookie.Value
+	}
+	return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, id))
+}
+
+func sessionID(r *http.Request) string {
+	id, _ := r.Context().Value(sessionContextKey{}).(string)
+	return id
+}
+
+// apply updates the session of the request, starting a new one with a
+// cookie when the request does not belong to one. Values are rendered with
+// data. The returned request carries the session so it is visible when
+// rendering the response.
+func (u SessionUpdate) apply(w http.ResponseWriter, r *http.Request, data templateData, config *SessionConfig) (*http.Request, error) {
+	id := sessionID(r)
+
+	if u.Destroy {
+		if id != "" {
+			sessions.Destroy(id)
+		}
+		http.SetCookie(w, &http.Cookie{Name: config.cookieName(), Value: "", Path: "/", MaxAge: -1})
+		return r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, "")), nil
+	}
+
+	values, err := renderTemplateValues(u.Set, data)
+
+	if err != nil {
+		return r, err
+	}
+
+	set, _ := values.(map[string]interface{})
+
+	var ttl time.Duration
+	if config != nil {
+		ttl = config.TTL.Duration
+	}
+
+	if id == "" {
+		id = uuid()
+		cookie := &http.Cookie{Name: config.cookieName(), Value: id, Path: "/", HttpOnly: true}
+		if config != nil {
+			cookie.Secure = config.Secure
+			cookie.SameSite = sameSite(config.SameSite)
+		}
+		if ttl > 0 {
+			cookie.MaxAge = int(ttl.Seconds())
+		}
+		http.SetCookie(w, cookie)
+		r = r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, id))
+	}
+
+	sessions.Update(id, set, u.Delete, ttl)
+
+	return r, nil
+}
@@ -0,0 +1,170 @@
+package app_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/inquizarus/rwapper/v2/pkg/servemuxwrapper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatResponsesCanSetSeveralCookies(t *testing.T) {
+	route := app.Route{
+		Name:   "cookies",
+		Path:   "/",
+		Method: http.MethodGet,
+		Response: app.Response{
+			StatusCode: http.StatusOK,
+			Cookies: []app.Cookie{
+				{Name: "theme", Value: "dark", Path: "/", MaxAge: 60},
+				{Name: "token", Value: "{{.Request.Query.Get \"token\"}}", Domain: "example.com", HTTPOnly: true, Secure: true, SameSite: "strict"},
+			},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodGet, "/?token=abc", nil))
+
+	assert.Equal(t, []string{
+		"theme=dark; Path=/; Max-Age=60",
+		"token=abc; Domain=example.com; HttpOnly; Secure; SameSite=Strict",
+	}, w.Header().Values("Set-Cookie"))
+}
+
+func TestThatSessionsCanBeUsedInTemplatesAndMatchers(t *testing.T) {
+	config := app.Config{
+		Session: &app.SessionConfig{CookieName: "sid"},
+		Routes: []app.Route{
+			{
+				Name:   "login",
+				Path:   "/login",
+				Method: http.MethodPost,
+				Response: app.Response{
+					StatusCode: http.StatusOK,
+					Session:    &app.SessionUpdate{Set: map[string]interface{}{"user": "{{.Request.Query.Get \"user\"}}", "role": "admin"}},
+					Body:       "hello {{.Session.user}}",
+					Template:   true,
+				},
+			},
+			{
+				Name:   "admin",
+				Path:   "/me",
+				Method: http.MethodGet,
+				Match:  &app.RequestMatcher{Session: map[string]app.Matcher{"role": {Exact: "admin"}}},
+				Response: app.Response{
+					StatusCode: http.StatusOK,
+					Headers:    map[string]string{"content-type": "application/json"},
+					Body:       map[string]interface{}{"user": "{{.Session.user}}", "admin": true},
+					Template:   true,
+				},
+			},
+			{
+				Name:     "anonymous",
+				Path:     "/me",
+				Method:   http.MethodGet,
+				Response: app.Response{StatusCode: http.StatusUnauthorized, Body: "who are you?"},
+			},
+			{
+				Name:   "logout",
+				Path:   "/logout",
+				Method: http.MethodPost,
+				Response: app.Response{
+					StatusCode: http.StatusNoContent,
+					Session:    &app.SessionUpdate{Destroy: true},
+				},
+			},
+		},
+	}
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(config, router, testLogger)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	do := func(method, path string) (int, string) {
+		request, _ := http.NewRequest(method, server.URL+path, nil)
+		response, err := client.Do(request)
+		assert.NoError(t, err)
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response.StatusCode, string(body)
+	}
+
+	status, body := do(http.MethodGet, "/me")
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, "who are you?", body)
+
+	status, body = do(http.MethodPost, "/login?user=alice")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "hello alice", body)
+
+	status, body = do(http.MethodGet, "/me")
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "{\n \"admin\": true,\n \"user\": \"alice\"\n}", body)
+
+	status, _ = do(http.MethodPost, "/logout")
+	assert.Equal(t, http.StatusNoContent, status)
+
+	status, _ = do(http.MethodGet, "/me")
+	assert.Equal(t, http.StatusUnauthorized, status)
+}
+
+func TestThatUnmatchedRequestsAreNotFound(t *testing.T) {
+	config := app.Config{
+		Routes: []app.Route{
+			{
+				Name:     "admin",
+				Path:     "/me",
+				Method:   http.MethodGet,
+				Match:    &app.RequestMatcher{Session: map[string]app.Matcher{"role": {Exact: "admin"}}},
+				Response: app.Response{StatusCode: http.StatusOK},
+			},
+		},
+	}
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(config, router, testLogger)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("Allow"))
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/me", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, http.MethodGet, w.Header().Get("Allow"))
+}
+
+func TestThatInvalidMatchersAreReportedWhenRoutesAreAdded(t *testing.T) {
+	log := &recordingLogger{}
+	config := app.Config{
+		Routes: []app.Route{
+			{
+				Name:     "beta",
+				Path:     "/items",
+				Method:   http.MethodGet,
+				Match:    &app.RequestMatcher{Headers: map[string]app.Matcher{"X-Channel": {Regex: "(beta"}}},
+				Response: app.Response{StatusCode: http.StatusOK, Body: "beta"},
+			},
+			{Name: "stable", Path: "/items", Method: http.MethodGet, Response: app.Response{StatusCode: http.StatusOK, Body: "stable"}},
+		},
+	}
+
+	router := servemuxwrapper.New(nil)
+	app.RegisterRoutes(config, router, log)
+
+	assert.True(t, log.contains(`could not set up matcher for route beta, header X-Channel has an invalid regex "(beta"`))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `invalid regex "(beta"`)
+}
@@ -0,0 +1,169 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/inquizarus/gomsvc/pkg/logging"
+)
+
+// defaultSSELoopInterval is waited between iterations of a looping stream
+// whose events are all sent without waiting, so it does not spin.
+const defaultSSELoopInterval = time.Second
+
+type SSE struct {
+	Events   []SSEEvent `json:"events"`
+	Interval Duration   `json:"interval"`
+	Loop     bool       `json:"loop"`
+	KeepOpen bool       `json:"keep_open"`
+	AutoID   bool       `json:"auto_id"`
+}
+
+type SSEEvent struct {
+	Event string      `json:"event"`
+	ID    string      `json:"id"`
+	Data  interface{} `json:"data"`
+	Retry int         `json:"retry"`
+	Delay *Duration   `json:"delay"`
+}
+
+// Serve streams the configured events to the client until the script is
+// done or the client disconnects. A Last-Event-ID header in the request
+// resumes the script after the event with that id.
+func (s SSE) Serve(w http.ResponseWriter, r *http.Request, log logging.Logger) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	data := newTemplateData(r, true)
+	sequence := s.resumeSequence(r, data)
+	waited := time.Duration(0)
+
+	for len(s.Events) > 0 && (s.Loop || sequence < len(s.Events)) {
+		event := s.Events[sequence%len(s.Events)]
+
+		delay := s.Interval.Duration
+		if event.Delay != nil {
+			delay = event.Delay.Duration
+		}
+
+		if sequence > 0 && sequence%len(s.Events) == 0 {
+			if waited <= 0 {
+				delay = defaultSSELoopInterval
+			}
+			waited = 0
+		}
+		waited += delay
+
+		select {
+		case <-r.Context().Done():
+			log.Info("client disconnected from event stream")
+			return
+		case <-time.After(delay):
+		}
+
+		data.Index = sequence % len(s.Events)
+		data.Iteration = sequence / len(s.Events)
+		data.Sequence = sequence
+
+		message, err := s.format(event, data)
+		if err != nil {
+			log.Error("could not render event " + strconv.Itoa(sequence) + ", " + err.Error())
+			return
+		}
+
+		if _, err := w.Write(message); err != nil {
+			log.Info("could not write event to stream, " + err.Error())
+			return
+		}
+
+		flusher.Flush()
+		sequence++
+	}
+
+	if s.KeepOpen {
+		<-r.Context().Done()
+	}
+}
+
+// resumeSequence finds where in the script to start from based on the
+// Last-Event-ID header, ids are compared as they are rendered in the
+// first iteration of the script.
+func (s SSE) resumeSequence(r *http.Request, data templateData) int {
+	lastEventID := r.Header.Get("Last-Event-ID")
+
+	if lastEventID == "" {
+		return 0
+	}
+
+	if s.AutoID {
+		if n, err := strconv.Atoi(lastEventID); err == nil {
+			return n + 1
+		}
+		return 0
+	}
+
+	for i, event := range s.Events {
+		data.Index = i
+		data.Sequence = i
+		if id, err := renderTemplate(event.ID, data); err == nil && id != "" && id == lastEventID {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+func (s SSE) format(event SSEEvent, data templateData) ([]byte, error) {
+	var b strings.Builder
+
+	name, err := renderTemplate(event.Event, data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := renderTemplate(event.ID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.AutoID && id == "" {
+		id = strconv.Itoa(data.Sequence)
+	}
+
+	payload, err := renderContent(event.Data, data)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != "" {
+		b.WriteString("event: " + name + "\n")
+	}
+
+	if id != "" {
+		b.WriteString("id: " + id + "\n")
+	}
+
+	if event.Retry > 0 {
+		b.WriteString(fmt.Sprintf("retry: %d\n", event.Retry))
+	}
+
+	for _, line := range strings.Split(string(payload), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,111 @@
+package app_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatSSEStreamsScriptedEvents(t *testing.T) {
+	sse := app.SSE{
+		Events: []app.SSEEvent{
+			{Event: "greeting", ID: "1", Data: "hello {{.Request.Query.Get \"name\"}}", Retry: 1000},
+			{Event: "update", ID: "2", Data: map[string]interface{}{"index": "{{.Index}}"}},
+			{ID: "3", Data: "multi\nline"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/events?name=world", nil)
+
+	sse.Serve(w, r, testLogger)
+
+	expected := "event: greeting\nid: 1\nretry: 1000\ndata: hello world\n\n" +
+		"event: update\nid: 2\ndata: {\"index\":\"1\"}\n\n" +
+		"id: 3\ndata: multi\ndata: line\n\n"
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, expected, w.Body.String())
+}
+
+func TestThatSSEResumesFromLastEventID(t *testing.T) {
+	sse := app.SSE{
+		Events: []app.SSEEvent{
+			{ID: "a", Data: "first"},
+			{ID: "b", Data: "second"},
+			{ID: "c", Data: "third"},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/events", nil)
+	r.Header.Set("Last-Event-ID", "b")
+
+	sse.Serve(w, r, testLogger)
+
+	assert.Equal(t, "id: c\ndata: third\n\n", w.Body.String())
+}
+
+func TestThatSSELoopsWithAutoIDsUntilClientDisconnects(t *testing.T) {
+	sse := app.SSE{
+		Events: []app.SSEEvent{
+			{Data: "{{.Iteration}}-{{.Index}}"},
+			{Data: "{{.Iteration}}-{{.Index}}"},
+		},
+		Interval: app.Duration{Duration: 10 * time.Millisecond},
+		Loop:     true,
+		AutoID:   true,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse.Serve(w, r, testLogger)
+	}))
+	defer server.Close()
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	request.Header.Set("Last-Event-ID", "2")
+
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+
+	expected := "id: 3\ndata: 1-1\n\nid: 4\ndata: 2-0\n\n"
+	buf := make([]byte, len(expected))
+	read := 0
+	for read < len(buf) && err == nil {
+		var n int
+		n, err = response.Body.Read(buf[read:])
+		read += n
+	}
+	response.Body.Close()
+
+	assert.Equal(t, expected, string(buf))
+}
+
+func TestThatSSELoopsWithoutWaitingDoNotSpin(t *testing.T) {
+	sse := app.SSE{Events: []app.SSEEvent{{Data: "tick"}}, Loop: true}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse.Serve(w, r, testLogger)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	response, err := http.DefaultClient.Do(request)
+	assert.NoError(t, err)
+	assert.Empty(t, response.Header.Get("Connection"))
+
+	data, _ := io.ReadAll(response.Body)
+	response.Body.Close()
+
+	assert.Equal(t, 1, strings.Count(string(data), "data: tick"))
+}
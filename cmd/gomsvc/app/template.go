@@ -0,0 +1,251 @@
+package app
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+)
+
+var templateFuncs = template.FuncMap{
+	"now":     time.Now,
+	"uuid":    uuid,
+	"randInt": randInt,
+	"pick":    pick,
+	"json":    toJSON,
+	"upper":   strings.ToUpper,
+	"lower":   strings.ToLower,
+	"env":     os.Getenv,
+}
+
+// maxRequestBodySize is the largest incoming request body that is read for
+// templates and forwarded to upstreams.
+const maxRequestBodySize = 10 << 20
+
+var templateCache sync.Map
+
+// templateData is what templates in the configuration are executed with.
+// Fields that do not apply where a template is used are left empty.
+type templateData struct {
+	Request   templateRequest
+	Index     int
+	Iteration int
+	Sequence  int
+	Message   interface{}
+	Session   map[string]interface{}
+	Upstreams []templateUpstream
+}
+
+type templateRequest struct {
+	Method   string
+	Path     string
+	Query    url.Values
+	Headers  http.Header
+	Cookies  map[string]string
+	ClientIP string
+	Body     interface{}
+	// body is the body as it was received, for upstreams forwarding it
+	body []byte
+	// bodyErr is set when the body could not be read
+	bodyErr error
+}
+
+// newTemplateData describes request for templates, its body is only read
+// when readBody is set.
+func newTemplateData(request *http.Request, readBody bool) templateData {
+	data := templateData{}
+	if request != nil {
+		data.Request = templateRequest{
+			Method:   request.Method,
+			Path:     request.URL.Path,
+			Query:    request.URL.Query(),
+			Headers:  request.Header,
+			Cookies:  requestCookies(request),
+			ClientIP: httptools.ClientIP(request),
+		}
+		if readBody {
+			data.Request.body, data.Request.bodyErr = requestBody(request)
+			data.Request.Body = decodeRequestBody(request.Header, data.Request.body)
+		}
+		data.Session = sessions.Values(sessionID(request))
+	}
+	return data
+}
+
+func requestCookies(request *http.Request) map[string]string {
+	cookies := map[string]string{}
+	for _, cookie := range request.Cookies() {
+		cookies[cookie.Name] = cookie.Value
+	}
+	return cookies
+}
+
+// requestBody returns the body of an incoming request, which is put back
+// so it can be read again. Outgoing requests, which have no request URI,
+// are left alone since their body belongs to the transport. Bodies larger
+// than maxRequestBodySize are not read.
+func requestBody(request *http.Request) ([]byte, error) {
+	if request.RequestURI == "" || request.Body == nil || request.Body == http.NoBody {
+		return nil, nil
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(nil, request.Body, maxRequestBodySize))
+	request.Body.Close()
+	request.Body = io.NopCloser(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// readsRequestBody reports whether handling requests to the route needs
+// their body, which is when it is forwarded, used by templates or kept
+// for callbacks made after the request has been handled.
+func (r Route) readsRequestBody() bool {
+	for _, upstream := range r.Upstreams {
+		if upstream.ForwardRequestBody || upstream.Async {
+			return true
+		}
+	}
+	return r.Response.Template || usesTemplates(r.Upstreams) || usesTemplates(r.Response.Cookies) || usesTemplates(r.Response.Session)
+}
+
+// usesTemplates reports whether any string in value is a template.
+func usesTemplates(value interface{}) bool {
+	data, _ := json.Marshal(value)
+	return bytes.Contains(data, []byte("{{"))
+}
+
+// decodeRequestBody returns the body for templates, JSON bodies are decoded
+// so their fields can be used directly.
+func decodeRequestBody(header http.Header, data []byte) interface{} {
+	if len(data) == 0 {
+		return nil
+	}
+
+	if httptools.IsJSON(header) {
+		var container interface{}
+		if err := json.Unmarshal(data, &container); err == nil {
+			return container
+		}
+	}
+
+	return string(data)
+}
+
+// renderTemplate executes text as a text/template with data, parsed
+// templates are cached since the same configuration is rendered repeatedly.
+func renderTemplate(text string, data interface{}) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	var tmpl *template.Template
+
+	if cached, ok := templateCache.Load(text); ok {
+		tmpl = cached.(*template.Template)
+	} else {
+		parsed, err := template.New("").Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+		if err != nil {
+			return "", err
+		}
+		templateCache.Store(text, parsed)
+		tmpl = parsed
+	}
+
+	var buf bytes.Buffer
+
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// renderContent renders value for sending, strings are used as templates
+// and anything else is encoded as JSON with its string values rendered.
+func renderContent(value interface{}, data interface{}) ([]byte, error) {
+	if text, ok := value.(string); ok {
+		rendered, err := renderTemplate(text, data)
+		return []byte(rendered), err
+	}
+
+	rendered, err := renderTemplateValues(value, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(rendered)
+}
+
+// renderTemplateValues renders every string found in value as a template.
+func renderTemplateValues(value interface{}, data interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return renderTemplate(v, data)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := renderTemplateValues(item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderTemplateValues(item, data)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+func uuid() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// randInt returns a random number in the range [min, max]
+func randInt(min, max int) int {
+	if max <= min {
+		return min
+	}
+	n, _ := rand.Int(rand.Reader, big.NewInt(int64(max-min+1)))
+	return min + int(n.Int64())
+}
+
+func pick(items ...interface{}) interface{} {
+	if len(items) == 0 {
+		return nil
+	}
+	return items[randInt(0, len(items)-1)]
+}
+
+func toJSON(value interface{}) (string, error) {
+	data, err := json.Marshal(value)
+	return string(data), err
+}
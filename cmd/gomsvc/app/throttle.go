@@ -0,0 +1,113 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const defaultThrottleChunkSize = 1024
+
+type Throttle struct {
+	ChunkSize      int      `json:"chunk_size"`
+	ChunkDelay     Duration `json:"chunk_delay"`
+	BytesPerSecond int      `json:"bytes_per_second"`
+	Chunked        bool     `json:"chunked"`
+}
+
+// Serve writes data in chunks with a delay between each of them, either the
+// configured chunk delay or whatever delay keeps the rate at bytes per second.
+// Writing stops when the client goes away.
+func (t Throttle) Serve(w http.ResponseWriter, r *http.Request, statusCode int, data []byte) error {
+	flusher, canFlush := w.(http.Flusher)
+
+	// Without a Content-Length and with the headers flushed before the body
+	// the server has no other choice than to use chunked transfer encoding
+	if t.Chunked {
+		w.Header().Del("Content-Length")
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	}
+
+	w.WriteHeader(statusCode)
+
+	if canFlush {
+		flusher.Flush()
+	}
+
+	_, err := t.Writer(w, r).Write(data)
+
+	return err
+}
+
+// Writer wraps w so everything written to it is throttled, which lets
+// handlers that write on their own such as http.ServeContent be throttled.
+func (t Throttle) Writer(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	chunkSize, delay := t.chunking()
+	return &throttledWriter{ResponseWriter: w, request: r, chunkSize: chunkSize, delay: delay}
+}
+
+type throttledWriter struct {
+	http.ResponseWriter
+	request   *http.Request
+	chunkSize int
+	delay     time.Duration
+	written   bool
+}
+
+func (tw *throttledWriter) Write(data []byte) (int, error) {
+	flusher, canFlush := tw.ResponseWriter.(http.Flusher)
+	written := 0
+
+	for offset := 0; offset < len(data); offset += tw.chunkSize {
+		if tw.written && tw.delay > 0 {
+			select {
+			case <-tw.request.Context().Done():
+				return written, tw.request.Context().Err()
+			case <-time.After(tw.delay):
+			}
+		}
+
+		end := offset + tw.chunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		n, err := tw.ResponseWriter.Write(data[offset:end])
+		written += n
+		tw.written = true
+
+		if err != nil {
+			return written, err
+		}
+
+		if canFlush {
+			flusher.Flush()
+		}
+	}
+
+	return written, nil
+}
+
+// chunking returns the size of each chunk and the delay between them, a
+// rate without an explicit chunk size is split into ten chunks per second.
+func (t Throttle) chunking() (int, time.Duration) {
+	chunkSize := t.ChunkSize
+	delay := t.ChunkDelay.Duration
+
+	if t.BytesPerSecond > 0 {
+		if chunkSize <= 0 {
+			chunkSize = t.BytesPerSecond / 10
+		}
+		if chunkSize <= 0 {
+			chunkSize = 1
+		}
+		delay = time.Duration(float64(chunkSize) / float64(t.BytesPerSecond) * float64(time.Second))
+	}
+
+	if chunkSize <= 0 {
+		chunkSize = defaultThrottleChunkSize
+	}
+
+	return chunkSize, delay
+}
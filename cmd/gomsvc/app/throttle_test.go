@@ -0,0 +1,75 @@
+package app_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestThatThrottledResponsesAreWrittenInChunks(t *testing.T) {
+	body := strings.Repeat("x", 100)
+
+	tests := []struct {
+		name             string
+		throttle         app.Throttle
+		minDuration      time.Duration
+		transferEncoding []string
+		contentLength    int64
+	}{
+		{
+			name:          "chunk size and delay",
+			throttle:      app.Throttle{ChunkSize: 25, ChunkDelay: app.Duration{Duration: 20 * time.Millisecond}},
+			minDuration:   60 * time.Millisecond,
+			contentLength: 100,
+		},
+		{
+			name:          "bytes per second",
+			throttle:      app.Throttle{BytesPerSecond: 500},
+			minDuration:   90 * time.Millisecond,
+			contentLength: 100,
+		},
+		{
+			name:             "forced chunked transfer encoding",
+			throttle:         app.Throttle{Chunked: true},
+			transferEncoding: []string{"chunked"},
+			contentLength:    -1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throttle := tt.throttle
+			route := app.Route{
+				Name:   tt.name,
+				Path:   "/",
+				Method: http.MethodGet,
+				Response: app.Response{
+					StatusCode: http.StatusOK,
+					Body:       body,
+					Throttle:   &throttle,
+				},
+			}
+
+			server := httptest.NewServer(app.MakeHandlerFunc(route, app.Config{}, testLogger))
+			defer server.Close()
+
+			start := time.Now()
+			response, err := http.Get(server.URL)
+			assert.NoError(t, err)
+			data, err := io.ReadAll(response.Body)
+			response.Body.Close()
+
+			assert.NoError(t, err)
+			assert.Equal(t, body, string(data))
+			assert.GreaterOrEqual(t, time.Since(start), tt.minDuration)
+			assert.Equal(t, tt.transferEncoding, response.TransferEncoding)
+			assert.Equal(t, tt.contentLength, response.ContentLength)
+		})
+	}
+}
@@ -2,12 +2,12 @@ package app
 
 import (
 	"bytes"
-	"encoding/json"
-	"errors"
+	"context"
 	"io"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 type Upstream struct {
@@ -16,6 +16,35 @@ type Upstream struct {
 	Headers               map[string]string `json:"headers"`
 	Method                string            `json:"method"`
 	Body                  interface{}       `json:"body"`
+	Timeout               Duration          `json:"timeout"`
+	Retry                 *Retry            `json:"retry"`
+	CircuitBreaker        *CircuitBreaker   `json:"circuit_breaker"`
+	If                    string            `json:"if"`
+	Async                 bool              `json:"async"`
+	Delay                 Duration          `json:"delay"`
+	Client                *UpstreamClient   `json:"client"`
+	Auth                  *Auth             `json:"auth"`
+	ForwardRequestBody    bool              `json:"forward_request_body"`
+
+	// configuredURL is the URL before templates in it were rendered
+	configuredURL string
+}
+
+// upstreamResult is the outcome of calling an upstream, err is set when no
+// response was received at all.
+type upstreamResult struct {
+	upstream Upstream
+	response *http.Response
+	err      error
+	attempts []upstreamAttempt
+	// shortCircuited is set when the upstream was not called because its
+	// circuit breaker is open
+	shortCircuited bool
+	// skipped is set when the condition of the upstream said to not call it
+	skipped bool
+	// secrets used to authenticate, which are redacted from logs and the
+	// upstream section of responses
+	secrets []string
 }
 
 // Call takes all the information in the upstream and makes a request
@@ -23,14 +52,48 @@ type Upstream struct {
 // whatever value is in that environment variable as an URL instead for
 // the upstream call
 func (u Upstream) Call(client *http.Client, req *http.Request) (*http.Response, error) {
+	return u.CallContext(context.Background(), client, req)
+}
+
+// CallContext is like Call but the upstream request is canceled when ctx
+// is done or the timeout of the upstream passes. The body of the returned
+// response is read before returning since the timeout covers it as well.
+func (u Upstream) CallContext(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	response, _, err := u.callContext(ctx, client, req)
+	return response, err
+}
+
+// callContext is CallContext that also returns the secrets used to
+// authenticate the request.
+func (u Upstream) callContext(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, []string, error) {
 
-	body, err := u.body()
+	body, contentType, err := u.body()
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	request, err := http.NewRequest(u.method(), u.url(), body)
+	// The body is kept around since signing requests needs it
+	var data []byte
+
+	if body != nil {
+		if data, err = io.ReadAll(body); err != nil {
+			return nil, nil, err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	if u.Timeout.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, u.Timeout.Duration)
+		defer cancel()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, u.method(), u.url(), body)
+
+	if err != nil {
+		return nil, nil, err
+	}
 
 	if u.IncludeRequestHeaders && req != nil {
 		for name, values := range req.Header {
@@ -44,10 +107,27 @@ func (u Upstream) Call(client *http.Client, req *http.Request) (*http.Response,
 		request.Header.Set(k, v)
 	}
 
-	if err == nil {
-		return client.Do(request)
+	if contentType != "" {
+		request.Header.Set("Content-Type", contentType)
 	}
-	return nil, err
+
+	secrets, err := u.Auth.apply(ctx, client, request, data)
+
+	if err != nil {
+		return nil, secrets, err
+	}
+
+	response, err := client.Do(request)
+
+	if err != nil {
+		return nil, secrets, err
+	}
+
+	if _, err := upstreamBody(response); err != nil {
+		return nil, secrets, err
+	}
+
+	return response, secrets, nil
 }
 
 // method returns a normalized HTTP verb in CAPS
@@ -55,21 +135,14 @@ func (u Upstream) method() string {
 	return strings.ToUpper(u.Method)
 }
 
-func (u Upstream) body() (io.Reader, error) {
-	if (u.Method == http.MethodPost || u.Method == http.MethodPut) && u.Body != nil {
-		if contentType, ok := u.Headers["content-type"]; ok && contentType == "application/json" {
-			body := u.Body.(map[string]interface{})
-			data, err := json.Marshal(body)
-			if err != nil {
-				return nil, errors.New("could not marshal upstream body for " + u.URL + ", " + err.Error())
-			}
-			return bytes.NewReader(data), nil
-		}
-		if body, ok := u.Body.(string); ok {
-			return strings.NewReader(body), nil
-		}
+// breakerKey returns what the circuit breaker of the upstream is kept under,
+// which is the configured URL so that an upstream with templates in its URL
+// has one breaker and not one for every URL it was rendered into.
+func (u Upstream) breakerKey() string {
+	if u.configuredURL != "" {
+		u.URL = u.configuredURL
 	}
-	return nil, nil
+	return u.url()
 }
 
 func (u Upstream) url() string {
@@ -81,3 +154,137 @@ func (u Upstream) url() string {
 
 	return url
 }
+
+// upstreamBody reads the body of an upstream response and puts back a copy
+// of it, so the body can be read again by everything that uses it.
+func upstreamBody(response *http.Response) ([]byte, error) {
+	if response.Body == nil {
+		return []byte{}, nil
+	}
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	return data, err
+}
+
+// call calls the upstream until it succeeds or its retry policy gives up,
+// recording every attempt. The result holds the outcome of the last attempt.
+func (u Upstream) call(ctx context.Context, client *http.Client, req *http.Request) upstreamResult {
+	result := upstreamResult{upstream: u}
+	maxAttempts := u.Retry.maxAttempts()
+
+	if u.CircuitBreaker != nil {
+		breaker := breakers.get(u.breakerKey(), *u.CircuitBreaker)
+		if !breaker.allow() {
+			result.shortCircuited = true
+			result.response, result.err = u.CircuitBreaker.fallback(u.method(), u.url())
+			return result
+		}
+		defer func() {
+			breaker.record(result.failed())
+		}()
+	}
+
+	for n := 1; ; n++ {
+		started := time.Now()
+		response, secrets, err := u.callContext(ctx, client, req)
+		result.secrets = append(result.secrets, secrets...)
+		u.Auth.rejected(response)
+		attempt := upstreamAttempt{err: err, duration: time.Since(started)}
+		if response != nil {
+			attempt.statusCode = response.StatusCode
+		}
+		result.response, result.err = response, err
+
+		if n >= maxAttempts || ctx.Err() != nil || !u.Retry.retryable(response, err) {
+			result.attempts = append(result.attempts, attempt)
+			return result
+		}
+
+		attempt.delay = u.Retry.delay(n, response)
+		result.attempts = append(result.attempts, attempt)
+
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(attempt.delay):
+		}
+	}
+}
+
+// url returns the URL the upstream was called with.
+func (result upstreamResult) url() string {
+	if result.response != nil && result.response.Request != nil {
+		return result.response.Request.URL.String()
+	}
+	return result.upstream.url()
+}
+
+// information describes the result for the upstream section of JSON
+// responses, attempts are only included when the upstream can be retried.
+func (result upstreamResult) information(body interface{}) map[string]interface{} {
+	information := map[string]interface{}{"url": result.url()}
+
+	if result.skipped {
+		information["skipped"] = true
+		return information
+	}
+
+	if result.err != nil {
+		information["error"] = result.redact(result.err.Error())
+	} else {
+		information["headers"] = redactHeader(result.response.Header, result.secrets)
+		information["status_code"] = result.response.StatusCode
+		information["body"] = body
+	}
+
+	if result.shortCircuited {
+		information["circuit_breaker"] = BreakerStateOpen
+	}
+
+	if result.upstream.Retry != nil {
+		attempts := []interface{}{}
+		for i, attempt := range result.attempts {
+			attempts = append(attempts, result.attemptInformation(attempt, i+1))
+		}
+		information["attempts"] = attempts
+	}
+
+	return information
+}
+
+// attemptInformation describes attempt with the secrets of the upstream
+// redacted from its error.
+func (result upstreamResult) attemptInformation(attempt upstreamAttempt, number int) map[string]interface{} {
+	information := attempt.information(number)
+	if message, ok := information["error"].(string); ok {
+		information["error"] = result.redact(message)
+	}
+	return information
+}
+
+// redact replaces the secrets used to call the upstream in text.
+func (result upstreamResult) redact(text string) string {
+	return redact(text, result.secrets)
+}
+
+// xmlInformation is like information but shaped for XML, where every
+// attempt is a repeated attempt element.
+func (result upstreamResult) xmlInformation(body interface{}) map[string]interface{} {
+	information := result.information(body)
+	if attempts, ok := information["attempts"]; ok {
+		information["attempts"] = map[string]interface{}{"attempt": attempts}
+	}
+	return information
+}
+
+// resultsFromResponses wraps responses received elsewhere as results.
+func resultsFromResponses(responses []*http.Response) []upstreamResult {
+	results := []upstreamResult{}
+	for _, response := range responses {
+		if response != nil {
+			results = append(results, upstreamResult{response: response})
+		}
+	}
+	return results
+}
@@ -3,8 +3,14 @@ package app_test
 import (
 	"encoding/json"
 	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
@@ -134,3 +140,208 @@ func TestThatUpstreamCallExcludeRequestHeadersIfSupposedToo(t *testing.T) {
 
 	assert.True(t, called)
 }
+
+func TestThatJSONUpstreamPostWorksWithArraysAndScalars(t *testing.T) {
+	bodies := []interface{}{
+		[]interface{}{map[string]interface{}{"id": 1}, map[string]interface{}{"id": 2}},
+		42,
+		true,
+	}
+
+	for _, body := range bodies {
+		router := servemuxwrapper.New(nil)
+		called := false
+
+		router.Handle(http.MethodPost, "/", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+			payload, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			expectedPayload, _ := json.Marshal(body)
+			assert.Equal(t, string(expectedPayload), string(payload))
+		}))
+
+		server := httptest.NewServer(router)
+
+		upstream := app.Upstream{
+			URL:    server.URL,
+			Method: http.MethodPost,
+			Headers: map[string]string{
+				"content-type": "application/json",
+			},
+			Body: body,
+		}
+
+		_, err := upstream.Call(server.Client(), nil)
+
+		assert.NoError(t, err)
+		assert.True(t, called)
+		server.Close()
+	}
+}
+
+type capturedRequest struct {
+	method      string
+	contentType string
+	body        string
+}
+
+// captureServer records every request it gets in the order they arrive.
+func captureServer(t *testing.T) (*httptest.Server, func() []capturedRequest) {
+	var mu sync.Mutex
+	captured := []capturedRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		captured = append(captured, capturedRequest{method: r.Method, contentType: r.Header.Get("Content-Type"), body: string(payload)})
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []capturedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]capturedRequest{}, captured...)
+	}
+}
+
+func TestThatUpstreamBodiesAreSentForEveryMethodWithBodies(t *testing.T) {
+	server, captured := captureServer(t)
+
+	for _, method := range []string{"patch", http.MethodDelete, http.MethodGet} {
+		upstream := app.Upstream{
+			URL:     server.URL,
+			Method:  method,
+			Headers: map[string]string{"Content-Type": "application/json; charset=utf-8"},
+			Body:    map[string]interface{}{"id": 1},
+		}
+		_, err := upstream.Call(server.Client(), nil)
+		assert.NoError(t, err)
+	}
+
+	requests := captured()
+	assert.Equal(t, capturedRequest{method: http.MethodPatch, contentType: "application/json; charset=utf-8", body: `{"id":1}`}, requests[0])
+	assert.Equal(t, capturedRequest{method: http.MethodDelete, contentType: "application/json; charset=utf-8", body: `{"id":1}`}, requests[1])
+	assert.Equal(t, "", requests[2].body)
+}
+
+func TestThatUpstreamBodiesAreEncodedAsForms(t *testing.T) {
+	server, captured := captureServer(t)
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "report.csv"), []byte("id,name\n1,john\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "raw.xml"), []byte("<order id=\"1\"/>"), 0o644)
+
+	upstreams := []app.Upstream{
+		{
+			Method:  http.MethodPost,
+			Headers: map[string]string{"CONTENT-TYPE": "application/x-www-form-urlencoded"},
+			Body:    map[string]interface{}{"name": "john doe", "age": 42, "tags": []interface{}{"a", "b"}, "admin": false},
+		},
+		{
+			Method:  http.MethodPut,
+			Headers: map[string]string{"content-type": "multipart/form-data"},
+			Body:    map[string]interface{}{"name": "john", "report": "file:" + filepath.Join(dir, "report.csv")},
+		},
+		{
+			Method:  http.MethodPost,
+			Headers: map[string]string{"content-type": "application/xml"},
+			Body:    "file:" + filepath.Join(dir, "raw.xml"),
+		},
+	}
+
+	for _, upstream := range upstreams {
+		upstream.URL = server.URL
+		_, err := upstream.Call(server.Client(), nil)
+		assert.NoError(t, err)
+	}
+
+	requests := captured()
+
+	assert.Equal(t, "application/x-www-form-urlencoded", requests[0].contentType)
+	assert.Equal(t, "admin=false&age=42&name=john+doe&tags=a&tags=b", requests[0].body)
+
+	mediaType, params, err := mime.ParseMediaType(requests[1].contentType)
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/form-data", mediaType)
+	form, err := multipart.NewReader(strings.NewReader(requests[1].body), params["boundary"]).ReadForm(1024)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"john"}, form.Value["name"])
+	assert.Equal(t, "report.csv", form.File["report"][0].Filename)
+	file, _ := form.File["report"][0].Open()
+	data, _ := io.ReadAll(file)
+	assert.Equal(t, "id,name\n1,john\n", string(data))
+
+	assert.Equal(t, `<order id="1"/>`, requests[2].body)
+
+	// Form bodies have to be objects
+	_, err = app.Upstream{URL: server.URL, Method: http.MethodPost, Headers: map[string]string{"content-type": "application/x-www-form-urlencoded"}, Body: []interface{}{"a"}}.Call(server.Client(), nil)
+	assert.Error(t, err)
+}
+
+func TestThatUpstreamsCanForwardTheIncomingBody(t *testing.T) {
+	server, captured := captureServer(t)
+
+	route := app.Route{
+		Name:   "forward",
+		Path:   "/orders",
+		Method: http.MethodPost,
+		Upstreams: []app.Upstream{
+			{URL: server.URL, Method: http.MethodPut, ForwardRequestBody: true},
+			{URL: server.URL, Method: http.MethodPost, ForwardRequestBody: true, Headers: map[string]string{"content-type": "text/plain"}},
+		},
+		Response: app.Response{StatusCode: http.StatusCreated, Template: true, Body: `{{.Request.Body.id}}`},
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"id": "A1",  "items": [1, 2]}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, r)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "A1", w.Body.String())
+
+	requests := captured()
+	assert.Len(t, requests, 2)
+	assert.ElementsMatch(t, []capturedRequest{
+		{method: http.MethodPut, contentType: "application/json", body: `{"id": "A1",  "items": [1, 2]}`},
+		{method: http.MethodPost, contentType: "text/plain", body: `{"id": "A1",  "items": [1, 2]}`},
+	}, requests)
+}
+
+func TestThatForwardedBodiesAreChecked(t *testing.T) {
+	server, captured := captureServer(t)
+
+	route := app.Route{
+		Name:      "forward",
+		Path:      "/orders",
+		Method:    http.MethodPost,
+		Upstreams: []app.Upstream{{URL: server.URL, Method: http.MethodGet, ForwardRequestBody: true}},
+		Response:  app.Response{StatusCode: http.StatusCreated},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}")))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "forwards the request body but is called with GET")
+
+	route.Upstreams[0].Method = http.MethodPost
+
+	w = httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(strings.Repeat("a", 10<<20+1))))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Empty(t, captured())
+}
+
+func TestThatRoutesNotUsingTheBodyAcceptLargeBodies(t *testing.T) {
+	route := app.Route{
+		Name:     "upload",
+		Path:     "/uploads",
+		Method:   http.MethodPost,
+		Response: app.Response{StatusCode: http.StatusCreated, Body: "stored"},
+	}
+
+	w := httptest.NewRecorder()
+	app.MakeHandlerFunc(route, app.Config{}, testLogger)(w, httptest.NewRequest(http.MethodPost, "/uploads", strings.NewReader(strings.Repeat("a", 10<<20+1))))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "stored", w.Body.String())
+}
@@ -0,0 +1,245 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/inquizarus/gomsvc/pkg/logging"
+)
+
+var upgrader = websocket.Upgrader{
+	// gomsvc is a mock, so connections are accepted from any origin
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+type WebSocket struct {
+	Greetings   []WebSocketMessage  `json:"greetings"`
+	Replies     []WebSocketReply    `json:"replies"`
+	Periodic    []WebSocketPeriodic `json:"periodic"`
+	CloseAfter  Duration            `json:"close_after"`
+	CloseCode   int                 `json:"close_code"`
+	CloseReason string              `json:"close_reason"`
+}
+
+type WebSocketMessage struct {
+	Data   interface{} `json:"data"`
+	Binary bool        `json:"binary"`
+	Delay  Duration    `json:"delay"`
+}
+
+type WebSocketReply struct {
+	Match    Matcher            `json:"match"`
+	Messages []WebSocketMessage `json:"messages"`
+	Close    bool               `json:"close"`
+}
+
+type WebSocketPeriodic struct {
+	Interval Duration         `json:"interval"`
+	Message  WebSocketMessage `json:"message"`
+}
+
+// webSocketSession is a single upgraded connection, writes are serialized
+// since the connection only allows one concurrent writer.
+type webSocketSession struct {
+	config WebSocket
+	conn   *websocket.Conn
+	name   string
+	data   templateData
+	log    logging.Logger
+	mu     sync.Mutex
+	done   chan struct{}
+	once   sync.Once
+}
+
+// compile returns the configuration with the matchers of its replies
+// compiled, invalid ones are returned so they can be reported when the
+// route is set up.
+func (ws WebSocket) compile() (WebSocket, error) {
+	replies := make([]WebSocketReply, len(ws.Replies))
+	for i, reply := range ws.Replies {
+		match, err := reply.Match.compile()
+		if err != nil {
+			return ws, fmt.Errorf("websocket reply %d has an %w", i, err)
+		}
+		reply.Match = match
+		replies[i] = reply
+	}
+	ws.Replies = replies
+	return ws, nil
+}
+
+// Serve upgrades the request to a WebSocket and plays the configured
+// conversation until either side closes the connection.
+func (ws WebSocket) Serve(w http.ResponseWriter, r *http.Request, name string, log logging.Logger) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		log.Info("could not upgrade request to route " + name + " to websocket, " + err.Error())
+		return
+	}
+
+	session := &webSocketSession{
+		config: ws,
+		conn:   conn,
+		name:   name,
+		data:   newTemplateData(r, true),
+		log:    log,
+		done:   make(chan struct{}),
+	}
+
+	session.run()
+}
+
+func (s *webSocketSession) run() {
+	defer s.conn.Close()
+
+	for _, message := range s.config.Greetings {
+		if !s.send(message) {
+			return
+		}
+	}
+
+	for _, periodic := range s.config.Periodic {
+		go s.repeat(periodic)
+	}
+
+	if s.config.CloseAfter.Duration > 0 {
+		timer := time.AfterFunc(s.config.CloseAfter.Duration, s.close)
+		defer timer.Stop()
+	}
+
+	s.receive()
+}
+
+// receive reads incoming frames and answers them with the first reply
+// that matches, it returns when the connection is closed.
+func (s *webSocketSession) receive() {
+	defer s.stop()
+
+	for {
+		messageType, payload, err := s.conn.ReadMessage()
+		if err != nil {
+			s.log.Info("websocket connection to route " + s.name + " closed, " + err.Error())
+			return
+		}
+
+		s.record("received", messageType, payload)
+
+		data := s.data
+		var document interface{}
+		if err := json.Unmarshal(payload, &document); err == nil {
+			data.Message = document
+		} else {
+			data.Message = string(payload)
+		}
+
+		for _, reply := range s.config.Replies {
+			if !reply.Match.Match(string(payload)) {
+				continue
+			}
+			for _, message := range reply.Messages {
+				if !s.sendWithData(message, data) {
+					return
+				}
+			}
+			if reply.Close {
+				s.close()
+			}
+			break
+		}
+	}
+}
+
+func (s *webSocketSession) repeat(periodic WebSocketPeriodic) {
+	if periodic.Interval.Duration <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(periodic.Interval.Duration)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if !s.send(periodic.Message) {
+				return
+			}
+		}
+	}
+}
+
+func (s *webSocketSession) send(message WebSocketMessage) bool {
+	return s.sendWithData(message, s.data)
+}
+
+func (s *webSocketSession) sendWithData(message WebSocketMessage, data templateData) bool {
+	if message.Delay.Duration > 0 {
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(message.Delay.Duration):
+		}
+	}
+
+	payload, err := message.payload(data)
+
+	if err != nil {
+		s.log.Error("could not render websocket message for route " + s.name + ", " + err.Error())
+		return false
+	}
+
+	messageType := websocket.TextMessage
+	if message.Binary {
+		messageType = websocket.BinaryMessage
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.conn.WriteMessage(messageType, payload); err != nil {
+		s.log.Info("could not write websocket message for route " + s.name + ", " + err.Error())
+		return false
+	}
+
+	s.record("sent", messageType, payload)
+
+	return true
+}
+
+// close sends a close frame with the configured code, the connection is
+// torn down when the client answers or the read fails.
+func (s *webSocketSession) close() {
+	code := s.config.CloseCode
+	if code == 0 {
+		code = websocket.CloseNormalClosure
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, s.config.CloseReason), time.Now().Add(time.Second))
+	s.log.Infof("websocket route %s sent close frame with code %d", s.name, code)
+	s.conn.SetReadDeadline(time.Now().Add(time.Second))
+}
+
+func (s *webSocketSession) stop() {
+	s.once.Do(func() { close(s.done) })
+}
+
+func (s *webSocketSession) record(direction string, messageType int, payload []byte) {
+	kind := "text"
+	if messageType == websocket.BinaryMessage {
+		kind = "binary"
+	}
+	s.log.Infof("websocket route %s %s %s frame: %s", s.name, direction, kind, payload)
+}
+
+func (m WebSocketMessage) payload(data templateData) ([]byte, error) {
+	return renderContent(m.Data, data)
+}
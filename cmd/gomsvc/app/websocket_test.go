@@ -0,0 +1,168 @@
+package app_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/inquizarus/gomsvc/cmd/gomsvc/app"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (l *recordingLogger) record(args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, fmt.Sprint(args...))
+}
+
+func (l *recordingLogger) Info(args ...interface{})  { l.record(args...) }
+func (l *recordingLogger) Debug(args ...interface{}) { l.record(args...) }
+func (l *recordingLogger) Error(args ...interface{}) { l.record(args...) }
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.record(fmt.Sprintf(format, args...))
+}
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.record(fmt.Sprintf(format, args...))
+}
+func (l *recordingLogger) Errorf(format string, args ...interface{}) {
+	l.record(fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) contains(s string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, entry := range l.entries {
+		if strings.Contains(entry, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestThatWebSocketPlaysScriptedConversation(t *testing.T) {
+	log := &recordingLogger{}
+	route := app.Route{
+		Name:   "chat",
+		Path:   "/ws",
+		Method: http.MethodGet,
+		Kind:   app.RouteKindWebSocket,
+		WebSocket: &app.WebSocket{
+			Greetings: []app.WebSocketMessage{
+				{Data: "welcome {{.Request.Query.Get \"user\"}}"},
+			},
+			Replies: []app.WebSocketReply{
+				{
+					Match:    app.Matcher{Exact: "ping"},
+					Messages: []app.WebSocketMessage{{Data: "pong"}},
+				},
+				{
+					Match:    app.Matcher{JSON: map[string]interface{}{"type": "echo"}},
+					Messages: []app.WebSocketMessage{{Data: map[string]interface{}{"echo": "{{.Message.text}}"}}},
+				},
+				{
+					Match:    app.Matcher{Regex: "^bye"},
+					Messages: []app.WebSocketMessage{{Data: "see you"}},
+					Close:    true,
+				},
+			},
+			CloseCode:   4000,
+			CloseReason: "done",
+		},
+	}
+
+	server := httptest.NewServer(app.MakeHandlerFunc(route, app.Config{}, log))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws?user=alice", nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	read := func() string {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, payload, err := conn.ReadMessage()
+		assert.NoError(t, err)
+		return string(payload)
+	}
+
+	assert.Equal(t, "welcome alice", read())
+
+	conn.WriteMessage(websocket.TextMessage, []byte("ping"))
+	assert.Equal(t, "pong", read())
+
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"echo","text":"hi"}`))
+	assert.Equal(t, `{"echo":"hi"}`, read())
+
+	conn.WriteMessage(websocket.TextMessage, []byte("bye now"))
+	assert.Equal(t, "see you", read())
+
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, 4000))
+
+	assert.True(t, log.contains("websocket route chat received text frame: ping"))
+	assert.True(t, log.contains("websocket route chat sent text frame: pong"))
+}
+
+func TestThatWebSocketSendsPeriodicMessagesAndClosesAfterTimeout(t *testing.T) {
+	ws := app.WebSocket{
+		Periodic: []app.WebSocketPeriodic{
+			{Interval: app.Duration{Duration: 10 * time.Millisecond}, Message: app.WebSocketMessage{Data: "tick"}},
+		},
+		CloseAfter: app.Duration{Duration: 100 * time.Millisecond},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws.Serve(w, r, "ticker", testLogger)
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ticks := 0
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure))
+			break
+		}
+		assert.Equal(t, "tick", string(payload))
+		ticks++
+	}
+
+	assert.Greater(t, ticks, 1)
+}
+
+func TestThatWebSocketRepliesWithInvalidRegexAreReportedAtSetup(t *testing.T) {
+	log := &recordingLogger{}
+	route := app.Route{
+		Name:   "chat",
+		Path:   "/ws",
+		Method: http.MethodGet,
+		Kind:   app.RouteKindWebSocket,
+		WebSocket: &app.WebSocket{
+			Replies: []app.WebSocketReply{{Match: app.Matcher{Regex: "(unclosed"}}},
+		},
+	}
+
+	handler := app.MakeHandlerFunc(route, app.Config{}, log)
+
+	assert.True(t, log.contains("could not set up websocket for route chat"))
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/ws", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), `websocket reply 0 has an invalid regex "(unclosed"`)
+}
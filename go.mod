@@ -3,6 +3,8 @@ module github.com/inquizarus/gomsvc
 go 1.21
 
 require (
+	github.com/andybalholm/brotli v1.1.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/inquizarus/rwapper/v2 v2.1.0
 	github.com/sirupsen/logrus v1.9.0
 	github.com/stretchr/testify v1.8.1
@@ -0,0 +1,100 @@
+package httptools
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const wildcard = "*"
+
+type qualityValue struct {
+	value string
+	q     float64
+}
+
+// parseQualityValues splits a header such as Accept or Accept-Encoding into
+// its values and their q-values, values without a q parameter get 1.
+func parseQualityValues(header string) []qualityValue {
+	values := []qualityValue{}
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		value := qualityValue{q: 1}
+		params := strings.Split(part, ";")
+		value.value = strings.ToLower(strings.TrimSpace(params[0]))
+		for _, param := range params[1:] {
+			key, raw, found := strings.Cut(strings.TrimSpace(param), "=")
+			if !found || strings.ToLower(strings.TrimSpace(key)) != "q" {
+				continue
+			}
+			if q, err := strconv.ParseFloat(strings.TrimSpace(raw), 64); err == nil && q >= 0 && q <= 1 {
+				value.q = q
+			}
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// NegotiateContentType picks the offered content type that best matches
+// the given Accept header. More specific media ranges take precedence over
+// wildcards and ties between offers are resolved by the order of offers.
+// An empty Accept header accepts the first offer.
+func NegotiateContentType(accept string, offers []string) (string, bool) {
+	if len(offers) == 0 {
+		return "", false
+	}
+
+	if strings.TrimSpace(accept) == "" {
+		return offers[0], true
+	}
+
+	ranges := parseQualityValues(accept)
+	best := ""
+	bestQ := 0.0
+
+	for _, offer := range offers {
+		q := mediaTypeQuality(ranges, offer)
+		if q > bestQ {
+			best = offer
+			bestQ = q
+		}
+	}
+
+	return best, bestQ > 0
+}
+
+// mediaTypeQuality returns the q-value of the most specific media range
+// that matches the offer.
+func mediaTypeQuality(ranges []qualityValue, offer string) float64 {
+	mediaType, _, err := mime.ParseMediaType(offer)
+	if err != nil {
+		return 0
+	}
+
+	offerType, offerSubtype, _ := strings.Cut(mediaType, "/")
+	specificity := -1
+	q := 0.0
+
+	for _, r := range ranges {
+		rangeType, rangeSubtype, _ := strings.Cut(r.value, "/")
+		match := -1
+		switch {
+		case rangeType == offerType && rangeSubtype == offerSubtype:
+			match = 2
+		case rangeType == offerType && rangeSubtype == wildcard:
+			match = 1
+		case rangeType == wildcard && (rangeSubtype == wildcard || rangeSubtype == ""):
+			match = 0
+		}
+		if match > specificity {
+			specificity = match
+			q = r.q
+		}
+	}
+
+	return q
+}
@@ -0,0 +1,70 @@
+package httptools_test
+
+import (
+	"testing"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+)
+
+func TestNegotiateContentType(t *testing.T) {
+	offers := []string{"application/json", "application/xml", "text/csv; charset=utf-8"}
+
+	tests := []struct {
+		name     string
+		accept   string
+		expected string
+		ok       bool
+	}{
+		{
+			name:     "empty accept header picks first offer",
+			accept:   "",
+			expected: "application/json",
+			ok:       true,
+		},
+		{
+			name:     "exact match",
+			accept:   "application/xml",
+			expected: "application/xml",
+			ok:       true,
+		},
+		{
+			name:     "highest q-value wins",
+			accept:   "application/json;q=0.5, text/csv;q=0.9",
+			expected: "text/csv; charset=utf-8",
+			ok:       true,
+		},
+		{
+			name:     "specific range overrides wildcard",
+			accept:   "*/*;q=0.8, application/json;q=0.1",
+			expected: "application/xml",
+			ok:       true,
+		},
+		{
+			name:     "subtype wildcard",
+			accept:   "text/*",
+			expected: "text/csv; charset=utf-8",
+			ok:       true,
+		},
+		{
+			name:     "q zero excludes offer",
+			accept:   "application/json;q=0",
+			expected: "",
+			ok:       false,
+		},
+		{
+			name:     "nothing matches",
+			accept:   "image/png",
+			expected: "",
+			ok:       false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual, ok := httptools.NegotiateContentType(tt.accept, offers)
+			if actual != tt.expected || ok != tt.ok {
+				t.Errorf("expected %q (%v) but got %q (%v)", tt.expected, tt.ok, actual, ok)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package httptools
 
 import (
+	"mime"
 	"net/http"
 	"strings"
 )
@@ -8,15 +9,24 @@ import (
 const (
 	headerContentTypeKey  = "content-type"
 	contentTypeJSON       = "application/json"
+	contentTypeJSONSuffix = "+json"
 	contentTypePlainText  = "text/plain"
 	contentTypeXML        = "application/xml"
+	contentTypeTextXML    = "text/xml"
+	contentTypeXMLSuffix  = "+xml"
 	contentTypeHTML       = "text/html"
 	contentTypeFormURLEnc = "application/x-www-form-urlencoded"
 	contentTypeMultipart  = "multipart/form-data"
 )
 
+// IsJSON reports whether the content type is application/json or any
+// structured syntax type using the +json suffix such as application/problem+json.
 func IsJSON(headers http.Header) bool {
-	return headers.Get(headerContentTypeKey) == contentTypeJSON
+	mediaType, _, err := mime.ParseMediaType(headers.Get(headerContentTypeKey))
+	if err != nil {
+		return false
+	}
+	return mediaType == contentTypeJSON || strings.HasSuffix(mediaType, contentTypeJSONSuffix)
 }
 
 func IsPlainText(headers http.Header) bool {
@@ -24,16 +34,25 @@ func IsPlainText(headers http.Header) bool {
 	return contentType == contentTypePlainText || contentType == ""
 }
 
+// IsXML reports whether the content type is application/xml, text/xml or
+// any structured syntax type using the +xml suffix such as application/soap+xml.
 func IsXML(headers http.Header) bool {
-	return headers.Get(headerContentTypeKey) == contentTypeXML
+	mediaType, _, err := mime.ParseMediaType(headers.Get(headerContentTypeKey))
+	if err != nil {
+		return false
+	}
+	return mediaType == contentTypeXML || mediaType == contentTypeTextXML || strings.HasSuffix(mediaType, contentTypeXMLSuffix)
 }
 
 func IsHTML(headers http.Header) bool {
 	return strings.HasPrefix(headers.Get(headerContentTypeKey), contentTypeHTML)
 }
 
+// IsFormURLEncoded reports whether the content type is
+// application/x-www-form-urlencoded, parameters such as charset are allowed.
 func IsFormURLEncoded(headers http.Header) bool {
-	return headers.Get(headerContentTypeKey) == contentTypeFormURLEnc
+	mediaType, _, err := mime.ParseMediaType(headers.Get(headerContentTypeKey))
+	return err == nil && mediaType == contentTypeFormURLEnc
 }
 
 func IsMultipart(headers http.Header) bool {
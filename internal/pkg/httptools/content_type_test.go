@@ -27,6 +27,14 @@ func TestIsJSON(t *testing.T) {
 	if httptools.IsJSON(textReq.Header) {
 		t.Error("expected false, got true")
 	}
+
+	for _, contentType := range []string{"application/json; charset=utf-8", "application/problem+json"} {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Content-Type", contentType)
+		if !httptools.IsJSON(req.Header) {
+			t.Errorf("expected true for %s, got false", contentType)
+		}
+	}
 }
 
 func TestIsXML(t *testing.T) {
@@ -47,6 +55,14 @@ func TestIsXML(t *testing.T) {
 	if httptools.IsXML(textReq.Header) {
 		t.Error("expected false, got true")
 	}
+
+	for _, contentType := range []string{"text/xml; charset=utf-8", "application/soap+xml", "application/atom+xml"} {
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Content-Type", contentType)
+		if !httptools.IsXML(req.Header) {
+			t.Errorf("expected true for %s, got false", contentType)
+		}
+	}
 }
 
 func TestIsPlainText(t *testing.T) {
@@ -115,6 +131,11 @@ func TestIsFormURLEncoded(t *testing.T) {
 			contentType:    "application/x-www-form-urlencoded",
 			expectedResult: true,
 		},
+		{
+			name:           "Form URL-encoded content type with charset",
+			contentType:    "application/x-www-form-urlencoded; charset=utf-8",
+			expectedResult: true,
+		},
 		{
 			name:           "Plain text content type",
 			contentType:    "text/plain",
@@ -0,0 +1,85 @@
+package httptools
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	EncodingGzip     = "gzip"
+	EncodingDeflate  = "deflate"
+	EncodingBrotli   = "br"
+	EncodingIdentity = "identity"
+)
+
+// NegotiateEncoding picks the offered content coding that best matches the
+// given Accept-Encoding header, ties are resolved by the order of offers.
+// An empty string is returned when the response should not be encoded.
+func NegotiateEncoding(acceptEncoding string, offers []string) string {
+	codings := parseQualityValues(acceptEncoding)
+	best := ""
+	bestQ := 0.0
+
+	for _, offer := range offers {
+		offer = strings.ToLower(offer)
+		q := -1.0
+		for _, coding := range codings {
+			if coding.value == offer {
+				q = coding.q
+				break
+			}
+			if coding.value == wildcard {
+				q = coding.q
+			}
+		}
+		if q > bestQ {
+			best = offer
+			bestQ = q
+		}
+	}
+
+	return best
+}
+
+// IsSupportedEncoding reports whether Encode can produce the given coding.
+func IsSupportedEncoding(encoding string) bool {
+	switch strings.ToLower(encoding) {
+	case EncodingGzip, EncodingDeflate, EncodingBrotli:
+		return true
+	}
+	return false
+}
+
+// Encode compresses data with the given content coding.
+func Encode(encoding string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+
+	switch strings.ToLower(encoding) {
+	case EncodingGzip:
+		writer = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		// deflate is the zlib format and not raw deflate, see RFC 9110
+		writer = zlib.NewWriter(&buf)
+	case EncodingBrotli:
+		writer = brotli.NewWriter(&buf)
+	default:
+		return nil, errors.New("unsupported content encoding " + encoding)
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
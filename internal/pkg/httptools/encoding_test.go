@@ -0,0 +1,64 @@
+package httptools_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	offers := []string{"br", "gzip", "deflate"}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		expected       string
+	}{
+		{name: "no header", acceptEncoding: "", expected: ""},
+		{name: "single coding", acceptEncoding: "gzip", expected: "gzip"},
+		{name: "offer order breaks ties", acceptEncoding: "gzip, deflate, br", expected: "br"},
+		{name: "q-values", acceptEncoding: "br;q=0.5, deflate", expected: "deflate"},
+		{name: "wildcard", acceptEncoding: "*", expected: "br"},
+		{name: "wildcard with exclusion", acceptEncoding: "*, br;q=0", expected: "gzip"},
+		{name: "identity only", acceptEncoding: "identity", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, httptools.NegotiateEncoding(tt.acceptEncoding, offers))
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	data := []byte("hello, world! hello, world! hello, world!")
+
+	readers := map[string]func(io.Reader) (io.Reader, error){
+		"gzip":    func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"deflate": func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) },
+		"br":      func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+
+	for encoding, newReader := range readers {
+		t.Run(encoding, func(t *testing.T) {
+			encoded, err := httptools.Encode(encoding, data)
+			assert.NoError(t, err)
+
+			reader, err := newReader(bytes.NewReader(encoded))
+			assert.NoError(t, err)
+
+			decoded, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, data, decoded)
+		})
+	}
+
+	_, err := httptools.Encode("compress", data)
+	assert.Error(t, err)
+}
@@ -12,10 +12,11 @@ func FormatJSON(container interface{}) ([]byte, error) {
 	return FormatJSONData(data)
 }
 
+// FormatJSONData indents any valid JSON document, including top level
+// arrays and scalars.
 func FormatJSONData(data []byte) ([]byte, error) {
 	var err error
-
-	container := map[string]interface{}{}
+	var container interface{}
 
 	if err = json.Unmarshal(data, &container); err != nil {
 		return nil, err
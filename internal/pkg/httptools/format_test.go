@@ -25,6 +25,31 @@ func TestFormatJSONData(t *testing.T) {
 }`),
 			err: nil,
 		},
+		{
+			name:  "top level array",
+			input: []byte(`[{"id":1},{"id":2}]`),
+			expected: []byte(`[
+ {
+  "id": 1
+ },
+ {
+  "id": 2
+ }
+]`),
+			err: nil,
+		},
+		{
+			name:     "top level string",
+			input:    []byte(`"hello"`),
+			expected: []byte(`"hello"`),
+			err:      nil,
+		},
+		{
+			name:     "null",
+			input:    []byte(`null`),
+			expected: []byte(`null`),
+			err:      nil,
+		},
 		{
 			name:     "invalid JSON",
 			input:    []byte(`invalid JSON`),
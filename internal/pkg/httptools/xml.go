@@ -0,0 +1,316 @@
+package httptools
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	xmlAttributePrefix = "@"
+	xmlTextKey         = "#text"
+	xmlArrayItem       = "item"
+)
+
+// RawXML holds an already encoded XML document or fragment that should be
+// embedded as is when formatting XML.
+type RawXML []byte
+
+// FormatXML encodes container as an XML document with root as the name of
+// the root element. Object keys become child elements, keys prefixed with @
+// become attributes and the #text key becomes the text content of the element.
+// Arrays are written as repeated elements with the name of their key. Names
+// that are not valid in XML, such as keys with spaces, are made valid.
+func FormatXML(root string, container interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(xml.Header)
+
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", " ")
+
+	container, err := normalizeXMLValue(container)
+	if err != nil {
+		return nil, err
+	}
+
+	if items, ok := container.([]interface{}); ok {
+		container = map[string]interface{}{xmlArrayItem: items}
+	}
+
+	if err := encodeXMLElement(encoder, root, container); err != nil {
+		return nil, err
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// AppendXML re-encodes the XML document in data and adds children as
+// elements at the end of its root element.
+func AppendXML(data []byte, children map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+
+	buf.WriteString(xml.Header)
+
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", " ")
+
+	depth := 0
+	rooted := false
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			rooted = true
+		case xml.EndElement:
+			depth--
+			if depth == 0 {
+				for _, key := range sortedKeys(children) {
+					if err := encodeXMLElement(encoder, key, children[key]); err != nil {
+						return nil, err
+					}
+				}
+			}
+		case xml.ProcInst:
+			if t.Target == "xml" {
+				continue
+			}
+		}
+		if err := encodeXMLToken(encoder, token); err != nil {
+			return nil, err
+		}
+	}
+
+	if !rooted {
+		return nil, errors.New("could not append to XML document, no root element found")
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func encodeXMLElement(encoder *xml.Encoder, name string, value interface{}) error {
+	if raw, ok := value.(RawXML); ok {
+		return encodeRawXMLElement(encoder, name, raw)
+	}
+
+	value, err := normalizeXMLValue(value)
+	if err != nil {
+		return err
+	}
+
+	if items, ok := value.([]interface{}); ok {
+		for _, item := range items {
+			if err := encodeXMLElement(encoder, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+	children := map[string]interface{}{}
+	text := ""
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			switch {
+			case strings.HasPrefix(key, xmlAttributePrefix):
+				start.Attr = append(start.Attr, xml.Attr{
+					Name:  xml.Name{Local: xmlName(strings.TrimPrefix(key, xmlAttributePrefix))},
+					Value: xmlScalar(v[key]),
+				})
+			case key == xmlTextKey:
+				text = xmlScalar(v[key])
+			default:
+				children[key] = v[key]
+			}
+		}
+	default:
+		text = xmlScalar(v)
+	}
+
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	if text != "" {
+		if err := encoder.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range sortedKeys(children) {
+		child := children[key]
+		if items, ok := child.([]interface{}); ok && len(items) > 0 {
+			if _, nested := items[0].([]interface{}); nested {
+				child = map[string]interface{}{xmlArrayItem: items}
+			}
+		}
+		if err := encodeXMLElement(encoder, key, child); err != nil {
+			return err
+		}
+	}
+
+	return encoder.EncodeToken(start.End())
+}
+
+// encodeRawXMLElement wraps the document in raw inside an element with
+// the given name, leaving out any XML declaration.
+func encodeRawXMLElement(encoder *xml.Encoder, name string, raw RawXML) error {
+	start := xml.StartElement{Name: xml.Name{Local: xmlName(name)}}
+
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(raw))
+
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if t, ok := token.(xml.ProcInst); ok && t.Target == "xml" {
+			continue
+		}
+		if err := encodeXMLToken(encoder, token); err != nil {
+			return err
+		}
+	}
+
+	return encoder.EncodeToken(start.End())
+}
+
+// encodeXMLToken writes a token read with RawToken, keeping namespace
+// prefixes as they were written in the source document.
+func encodeXMLToken(encoder *xml.Encoder, token xml.Token) error {
+	switch t := token.(type) {
+	case xml.StartElement:
+		t.Name = prefixedXMLName(t.Name)
+		attributes := make([]xml.Attr, 0, len(t.Attr))
+		for _, attr := range t.Attr {
+			attributes = append(attributes, xml.Attr{Name: prefixedXMLName(attr.Name), Value: attr.Value})
+		}
+		t.Attr = attributes
+		return encoder.EncodeToken(t)
+	case xml.EndElement:
+		t.Name = prefixedXMLName(t.Name)
+		return encoder.EncodeToken(t)
+	case xml.CharData:
+		if len(bytes.TrimSpace(t)) == 0 {
+			return nil
+		}
+		return encoder.EncodeToken(xml.CharData(bytes.TrimSpace(t)))
+	case xml.Directive:
+		return nil
+	}
+	return encoder.EncodeToken(token)
+}
+
+// xmlName turns name into a valid XML name, characters that are not
+// allowed are replaced with _ and names that can not start the way they do
+// get a leading _. Colons are kept so names can have namespace prefixes.
+func xmlName(name string) string {
+	var b strings.Builder
+
+	for i, r := range name {
+		valid := r == '_' || r == ':' || unicode.IsLetter(r)
+		if !valid && (r == '-' || r == '.' || unicode.IsDigit(r)) {
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			valid = true
+		}
+		if !valid {
+			r = '_'
+		}
+		b.WriteRune(r)
+	}
+
+	if b.Len() == 0 {
+		return "_"
+	}
+
+	return b.String()
+}
+
+func prefixedXMLName(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+// normalizeXMLValue turns typed values such as http.Header into the generic
+// shapes produced by encoding/json so they can be encoded the same way.
+func normalizeXMLValue(value interface{}) (interface{}, error) {
+	switch value.(type) {
+	case nil, string, bool, float64, json.Number, map[string]interface{}, []interface{}:
+		return value, nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var container interface{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	if err := decoder.Decode(&container); err != nil {
+		return nil, err
+	}
+
+	return container, nil
+}
+
+func xmlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
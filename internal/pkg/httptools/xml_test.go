@@ -0,0 +1,100 @@
+package httptools_test
+
+import (
+	"testing"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/httptools"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatXML(t *testing.T) {
+	container := map[string]interface{}{
+		"@xmlns:soap": "http://schemas.xmlsoap.org/soap/envelope/",
+		"soap:Body": map[string]interface{}{
+			"user": map[string]interface{}{
+				"@id":  1,
+				"name": "Alice & Bob",
+				"tags": []interface{}{"a", "b"},
+			},
+		},
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/">
+ <soap:Body>
+  <user id="1">
+   <name>Alice &amp; Bob</name>
+   <tags>a</tags>
+   <tags>b</tags>
+  </user>
+ </soap:Body>
+</soap:Envelope>`
+
+	actual, err := httptools.FormatXML("soap:Envelope", container)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
+
+func TestFormatXMLWithTopLevelArray(t *testing.T) {
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<list>
+ <item>1</item>
+ <item>2</item>
+</list>`
+
+	actual, err := httptools.FormatXML("list", []interface{}{1, 2})
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
+
+func TestAppendXML(t *testing.T) {
+	document := []byte(`<?xml version="1.0"?><ns:note xmlns:ns="urn:note"><ns:to>Tove</ns:to></ns:note>`)
+	children := map[string]interface{}{
+		"upstream": httptools.RawXML(`<?xml version="1.0"?><city>Oslo</city>`),
+		"request":  map[string]interface{}{"method": "GET"},
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<ns:note xmlns:ns="urn:note">
+ <ns:to>Tove</ns:to>
+ <request>
+  <method>GET</method>
+ </request>
+ <upstream>
+  <city>Oslo</city>
+ </upstream>
+</ns:note>`
+
+	actual, err := httptools.AppendXML(document, children)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
+
+func TestAppendXMLWithoutRootElement(t *testing.T) {
+	_, err := httptools.AppendXML([]byte("just text"), nil)
+	assert.Error(t, err)
+}
+
+func TestFormatXMLWithInvalidNames(t *testing.T) {
+	container := map[string]interface{}{
+		"1st-Header":  "a",
+		"first name":  "b",
+		"@data value": "c",
+		"":            "d",
+	}
+
+	expected := `<?xml version="1.0" encoding="UTF-8"?>
+<headers data_value="c">
+ <_>d</_>
+ <_1st-Header>a</_1st-Header>
+ <first_name>b</first_name>
+</headers>`
+
+	actual, err := httptools.FormatXML("headers", container)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(actual))
+}
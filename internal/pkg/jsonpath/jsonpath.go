@@ -0,0 +1,184 @@
+// Package jsonpath implements the subset of JSONPath needed to pick values
+// out of decoded JSON documents, such as $.users[0].name, $['a key'] and
+// $.users[*].id.
+package jsonpath
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type segment struct {
+	key      string
+	index    int
+	isIndex  bool
+	wildcard bool
+}
+
+// Path is a compiled JSONPath expression.
+type Path struct {
+	expression string
+	segments   []segment
+}
+
+func (p Path) String() string {
+	return p.expression
+}
+
+// Compile parses expression, the leading $ is optional.
+func Compile(expression string) (Path, error) {
+	path := Path{expression: expression}
+	rest := strings.TrimSpace(expression)
+	rest = strings.TrimPrefix(rest, "$")
+
+	for rest != "" {
+		switch {
+		case strings.HasPrefix(rest, "."):
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			if key == "" {
+				return path, errors.New("empty key in JSONPath " + expression)
+			}
+			if key == "*" {
+				path.segments = append(path.segments, segment{wildcard: true})
+			} else {
+				path.segments = append(path.segments, segment{key: key})
+			}
+			rest = rest[end:]
+		case strings.HasPrefix(rest, "["):
+			end := closingBracket(rest)
+			if end < 0 {
+				return path, errors.New("unclosed bracket in JSONPath " + expression)
+			}
+			inner := strings.TrimSpace(rest[1:end])
+			rest = rest[end+1:]
+			switch {
+			case inner == "*":
+				path.segments = append(path.segments, segment{wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				path.segments = append(path.segments, segment{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return path, errors.New("invalid index " + inner + " in JSONPath " + expression)
+				}
+				path.segments = append(path.segments, segment{index: index, isIndex: true})
+			}
+		default:
+			// Paths written without the leading $ or dot, like user.name
+			if len(path.segments) == 0 {
+				rest = "." + rest
+				continue
+			}
+			return path, errors.New("unexpected " + rest + " in JSONPath " + expression)
+		}
+	}
+
+	return path, nil
+}
+
+// Lookup returns the value at the path in document. Paths containing a
+// wildcard return an array with every value found.
+func (p Path) Lookup(document interface{}) (interface{}, bool) {
+	values := []interface{}{document}
+	wildcard := false
+
+	for _, s := range p.segments {
+		next := []interface{}{}
+		for _, value := range values {
+			next = append(next, s.apply(value)...)
+		}
+		if s.wildcard {
+			wildcard = true
+		}
+		values = next
+	}
+
+	if wildcard {
+		return values, true
+	}
+
+	if len(values) == 0 {
+		return nil, false
+	}
+
+	return values[0], true
+}
+
+// Lookup compiles expression and looks it up in document.
+func Lookup(document interface{}, expression string) (interface{}, bool, error) {
+	path, err := Compile(expression)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := path.Lookup(document)
+	return value, ok, nil
+}
+
+func (s segment) apply(value interface{}) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if s.wildcard {
+			values := make([]interface{}, 0, len(v))
+			for _, key := range sortedKeys(v) {
+				values = append(values, v[key])
+			}
+			return values
+		}
+		if s.isIndex {
+			return nil
+		}
+		if item, ok := v[s.key]; ok {
+			return []interface{}{item}
+		}
+	case []interface{}:
+		if s.wildcard {
+			return v
+		}
+		index := s.index
+		if !s.isIndex {
+			n, err := strconv.Atoi(s.key)
+			if err != nil {
+				return nil
+			}
+			index = n
+		}
+		if index < 0 {
+			index += len(v)
+		}
+		if index >= 0 && index < len(v) {
+			return []interface{}{v[index]}
+		}
+	}
+	return nil
+}
+
+func closingBracket(s string) int {
+	quote := byte(0)
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote != 0 && s[i] == quote:
+			quote = 0
+		case quote == 0 && (s[i] == '\'' || s[i] == '"'):
+			quote = s[i]
+		case quote == 0 && s[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,53 @@
+package jsonpath_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/inquizarus/gomsvc/internal/pkg/jsonpath"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookup(t *testing.T) {
+	var document interface{}
+	json.Unmarshal([]byte(`{
+		"users": [{"name": "john", "id": 1}, {"name": "jane", "id": 2}],
+		"meta": {"a key": "spaced", "total": 2}
+	}`), &document)
+
+	tests := []struct {
+		name       string
+		expression string
+		expected   interface{}
+		found      bool
+	}{
+		{name: "root", expression: "$", expected: document, found: true},
+		{name: "nested key", expression: "$.meta.total", expected: float64(2), found: true},
+		{name: "index", expression: "$.users[1].name", expected: "jane", found: true},
+		{name: "negative index", expression: "$.users[-1].id", expected: float64(2), found: true},
+		{name: "quoted key", expression: "$.meta['a key']", expected: "spaced", found: true},
+		{name: "double quoted key", expression: `$["meta"]["total"]`, expected: float64(2), found: true},
+		{name: "without dollar", expression: "meta.total", expected: float64(2), found: true},
+		{name: "dot index", expression: "$.users.0.name", expected: "john", found: true},
+		{name: "wildcard", expression: "$.users[*].name", expected: []interface{}{"john", "jane"}, found: true},
+		{name: "missing key", expression: "$.meta.missing", found: false},
+		{name: "index out of range", expression: "$.users[5]", found: false},
+		{name: "key on array", expression: "$.users.name", found: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, found, err := jsonpath.Lookup(document, test.expression)
+			assert.NoError(t, err)
+			assert.Equal(t, test.found, found)
+			assert.Equal(t, test.expected, value)
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expression := range []string{"$.users[0", "$.users[abc]", "$..name", "$.users[0]name"} {
+		_, err := jsonpath.Compile(expression)
+		assert.Error(t, err, expression)
+	}
+}